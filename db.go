@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/base64"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3" //sqlite driver
+	"github.com/rliu054/magnetsearch/dht"
 )
 
 const (
@@ -45,80 +45,45 @@ func (p *Persist) addResource(infohash string) error {
 	return err
 }
 
-func (p *Persist) deleteOldPeers() error {
-	stmt, err := p.db.Prepare("DELETE FROM Peers WHERE DATEDIFF(CURRENT_TIMESTAMP(), ctime) >= 1")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec()
-	return err
-}
-
-func (p *Persist) addPeer(infohash string, peer []byte) error {
-	stmt, err := p.db.Prepare("INSERT INTO Peers(infohash, peers) VALUES(?, ?)")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(infohash, base64.StdEncoding.EncodeToString(peer))
-	return err
-}
-
-func (p *Persist) loadPeers(infohash string) ([]string, error) {
-	stmt, err := p.db.Prepare("SELECT peers FROM Peers WHERE infohash = ? ORDER BY ctime DESC LIMIT 10")
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-
-	rows, _ := stmt.Query(infohash)
-	defer rows.Close()
-	var link string
-	var ret []string
-	for rows.Next() {
-		rows.Scan(&link)
-		data, _ := base64.StdEncoding.DecodeString(link)
-		ret = append(ret, bytes.NewBuffer(data).String())
-		// ret = append(ret, bytes.NewBuffer(data).String())
-	}
-	return ret, nil
-}
-
-func (p *Persist) updateNodeInfo(id Identifier, routing []byte) error {
+// SaveNode saves snapshot of node id.
+func (p *Persist) SaveNode(id dht.Identifier, routing []byte) error {
 	stmt, err := p.db.Prepare("REPLACE INTO Nodes(nodeid, routing, utime) VALUES(?, ?, CURRENT_TIMESTAMP)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(id.hexString(), base64.StdEncoding.EncodeToString(routing))
+	_, err = stmt.Exec(id.HexString(), base64.StdEncoding.EncodeToString(routing))
 	return err
 }
 
-// LoadAllNodeIDs reads from local datastore and returns all previously saved nodes.
-func (p *Persist) loadAllNodeIDs() ([]string, error) {
-	rows, err := p.db.Query("SELECT nodeid FROM Nodes")
+// NodeIDs reads from local datastore and returns all previously saved nodes,
+// most recently updated first.
+func (p *Persist) NodeIDs() ([]dht.Identifier, error) {
+	rows, err := p.db.Query("SELECT nodeid FROM Nodes ORDER BY utime DESC")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var id string
-	var ret []string
+	var nodeid string
+	var ret []dht.Identifier
 	for rows.Next() {
-		rows.Scan(&id)
-		ret = append(ret, id)
+		rows.Scan(&nodeid)
+		if id := dht.HexToID(nodeid); id != nil {
+			ret = append(ret, id)
+		}
 	}
 	return ret, nil
 }
 
-func (p *Persist) loadNodeInfo(id Identifier) ([]byte, error) {
+// LoadNode returns the snapshot saved for node id.
+func (p *Persist) LoadNode(id dht.Identifier) ([]byte, error) {
 	stmt, err := p.db.Prepare("SELECT routing FROM Nodes WHERE nodeid = ?")
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, _ := stmt.Query(id.hexString())
+	rows, _ := stmt.Query(id.HexString())
 	var routing string
 	for rows.Next() {
 		rows.Scan(&routing)
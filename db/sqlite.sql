@@ -15,9 +15,9 @@ create table Resources
 
 create table Peers
 (
-    id integer PRIMARY KEY,
-    infohash VARCHAR(40) not null,
-    peers TEXT,
-    ctime TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    infohash VARCHAR(40) NOT NULL,
+    ip TEXT NOT NULL,
+    port INTEGER NOT NULL,
+    expires INTEGER NOT NULL,
+    UNIQUE (infohash, ip, port)
 );
-
@@ -0,0 +1,132 @@
+package dht
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// announcement is an infohash this node announces to the DHT.
+type announcement struct {
+	infohash    Identifier
+	port        int
+	impliedPort bool
+	last        time.Time
+}
+
+// announcer keeps track of the infohashes a node announces.
+type announcer struct {
+	mu            sync.Mutex
+	announcements map[string]*announcement
+	wake          chan struct{}
+}
+
+func newAnnouncer() *announcer {
+	return &announcer{
+		announcements: make(map[string]*announcement),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Announce registers infohash to be announced with port. If impliedPort
+// is set, other nodes take the source port of our UDP packets instead.
+// Registered infohashes are announced right away and then every
+// ReannounceInterval, well before the tokens they are announced with expire.
+func (node *Node) Announce(infohash Identifier, port int, impliedPort bool) {
+	a := node.announcer
+	a.mu.Lock()
+	a.announcements[infohash.HexString()] = &announcement{
+		infohash:    infohash,
+		port:        port,
+		impliedPort: impliedPort,
+	}
+	a.mu.Unlock()
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startAnnouncer announces registered infohashes when they are due.
+func (node *Node) startAnnouncer() {
+	log.Printf("starting announcer...")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-node.announcer.wake:
+		case <-node.ctx.Done():
+			return
+		}
+		for _, a := range node.announcer.due() {
+			node.announce(a)
+		}
+	}
+}
+
+// due returns announcements which haven't been announced for
+// ReannounceInterval.
+func (a *announcer) due() []*announcement {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var due []*announcement
+	for _, ann := range a.announcements {
+		if time.Since(ann.last) >= config.ReannounceInterval {
+			ann.last = time.Now()
+			due = append(due, ann)
+		}
+	}
+	return due
+}
+
+// announce runs a get_peers lookup for a and sends announce_peer to the
+// closest nodes with the tokens they gave us.
+func (node *Node) announce(a *announcement) {
+	ctx, cancel := context.WithTimeout(node.ctx, config.LookupTimeout)
+	defer cancel()
+
+	result, err := node.GetPeers(ctx, a.infohash)
+	if err != nil {
+		log.Printf("error occurred while looking up %s for announce: %v", a.infohash.HexString(), err)
+		if result == nil {
+			return
+		}
+	}
+
+	var reqs []*Request
+	for _, c := range result.Closest {
+		token, ok := result.Tokens[c.id.HexString()]
+		if !ok {
+			continue
+		}
+		txid, data, err := node.krpc.encodeAnnouncePeer(node.info.id.String(), a.infohash, a.port, a.impliedPort, token)
+		if err != nil {
+			log.Printf("error occurred while encoding announce_peer to %s", c)
+			continue
+		}
+		r := NewRequest(c, txid)
+		if !node.track(r) {
+			break
+		}
+		if _, err = node.transport.writeMsgUDP([]byte(data), &net.UDPAddr{IP: c.ip, Port: c.port}); err != nil {
+			continue
+		}
+		reqs = append(reqs, r)
+	}
+
+	acked := 0
+	ch := checkResponses(ctx, reqs)
+	for i := 0; i < len(reqs); i++ {
+		if req := <-ch; req != nil {
+			if _, ok := req.resp.ext.(*Response); ok {
+				acked++
+			}
+		}
+	}
+	log.Printf("announced %s to %d of %d closest nodes", a.infohash.HexString(), acked, len(result.Closest))
+}
@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// bloom is a bloom filter of m bits with k hash functions.
+type bloom struct {
+	bits []uint64
+	m, k uint64
+	n    int // number of added keys
+}
+
+// newBloom returns a bloom filter which holds n keys at false positive
+// rate p.
+func newBloom(n int, p float64) *bloom {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// hashes returns the two hashes of key that the k bit positions are
+// derived from by double hashing.
+func hashes(key []byte) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write(key)
+	h2 := fnv.New64()
+	h2.Write(key)
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+func (b *bloom) add(key []byte) {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.n++
+}
+
+func (b *bloom) contains(key []byte) bool {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rotatingBloom remembers keys for one to two windows. Keys are added to
+// the current filter, which replaces the previous one when the window
+// has passed or it is full, so the false positive rate stays bounded
+// however many keys are seen.
+type rotatingBloom struct {
+	size   int
+	rate   float64
+	window time.Duration
+
+	mu       sync.Mutex
+	current  *bloom
+	previous *bloom
+	rotated  time.Time
+}
+
+func newRotatingBloom(size int, rate float64, window time.Duration) *rotatingBloom {
+	return &rotatingBloom{
+		size:     size,
+		rate:     rate,
+		window:   window,
+		current:  newBloom(size, rate),
+		previous: newBloom(size, rate),
+		rotated:  time.Now(),
+	}
+}
+
+// seen adds key to the filter, it reports whether key was
+// probably added before.
+func (r *rotatingBloom) seen(key []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotate()
+	if r.current.contains(key) {
+		return true
+	}
+	r.current.add(key)
+	return r.previous.contains(key)
+}
+
+// contains reports whether key was probably added before, without
+// adding it.
+func (r *rotatingBloom) contains(key []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotate()
+	return r.current.contains(key) || r.previous.contains(key)
+}
+
+// rotate replaces the previous filter with the current one when the
+// window has passed or it is full, r.mu must be held.
+func (r *rotatingBloom) rotate() {
+	if r.current.n >= r.size || time.Since(r.rotated) >= r.window {
+		r.previous, r.current = r.current, newBloom(r.size, r.rate)
+		r.rotated = time.Now()
+	}
+}
@@ -0,0 +1,36 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotatingBloom(t *testing.T) {
+	r := newRotatingBloom(100, 1e-6, time.Hour)
+	ids := make([]Identifier, 100)
+	for i := range ids {
+		ids[i] = RandomID()
+		if r.seen(ids[i]) {
+			t.Errorf("expected %s not to be seen", ids[i].HexString())
+		}
+	}
+	for _, id := range ids {
+		if !r.seen(id) {
+			t.Errorf("expected %s to be seen", id.HexString())
+		}
+	}
+
+	// the first ids move to the previous filter, then out of the filter
+	for i := 0; i < 200; i++ {
+		r.seen(RandomID())
+	}
+	forgotten := 0
+	for _, id := range ids {
+		if !r.seen(id) {
+			forgotten++
+		}
+	}
+	if forgotten < 90 {
+		t.Errorf("expected old ids to be forgotten after two rotations, %d of 100 were", forgotten)
+	}
+}
@@ -0,0 +1,178 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bootstrap sources are given as
+//
+//	host:port   resolved with DNS, e.g. router.bittorrent.com:6881
+//	ip:port     literal address, e.g. 67.215.246.10:6881
+//	file:path   a libtorrent or Transmission DHT state file
+//	table       contacts saved in node snapshots in the node store
+const (
+	SourceFile  = "file:"
+	SourceTable = "table"
+)
+
+// bootstrapHealth records how a bootstrap node has been answering.
+type bootstrapHealth struct {
+	queried  int
+	answered int
+	lastSeen time.Time
+}
+
+// bootstrapper resolves bootstrap sources into contacts and keeps track of
+// which bootstrap nodes answer, nodes which never answer are skipped.
+// A bootstrapper can be shared by several nodes.
+type bootstrapper struct {
+	sources []string
+	store   NodeStore
+
+	mu     sync.Mutex
+	health map[string]*bootstrapHealth // keyed by ip:port
+}
+
+func newBootstrapper(sources []string, store NodeStore) *bootstrapper {
+	return &bootstrapper{
+		sources: sources,
+		store:   store,
+		health:  make(map[string]*bootstrapHealth),
+	}
+}
+
+// contacts resolves all sources, sources that fail are logged and skipped.
+// If every resolved node looks dead, their health is reset and all of them
+// are tried again.
+func (b *bootstrapper) contacts(ctx context.Context) []*Contact {
+	var contacts, dead []*Contact
+	resolved := 0
+	for _, source := range b.sources {
+		cs, err := b.resolve(ctx, source)
+		if err != nil {
+			log.Printf("bootstrap: error occurred while resolving %s: %v", source, err)
+			continue
+		}
+		resolved++
+		for _, c := range cs {
+			if b.dead(c) {
+				dead = append(dead, c)
+				continue
+			}
+			contacts = append(contacts, c)
+		}
+	}
+	if len(contacts) == 0 && len(dead) > 0 {
+		log.Printf("bootstrap: all %d resolved nodes are unresponsive, retrying them", len(dead))
+		b.forget(dead)
+		contacts, dead = dead, nil
+	}
+	log.Printf("bootstrap: %d of %d sources resolved to %d nodes, %d unresponsive nodes skipped",
+		resolved, len(b.sources), len(contacts), len(dead))
+	return contacts
+}
+
+// resolve turns one source into contacts, DNS lookups are retried
+// with exponential backoff until ctx is done.
+func (b *bootstrapper) resolve(ctx context.Context, source string) ([]*Contact, error) {
+	switch {
+	case source == SourceTable:
+		return b.persistedContacts()
+	case strings.HasPrefix(source, SourceFile):
+		f, err := os.Open(strings.TrimPrefix(source, SourceFile))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ImportState(f)
+	}
+
+	var addr *net.UDPAddr
+	var err error
+	backoff := config.BootstrapBackoff
+	for i := 0; i < config.BootstrapRetries; i++ {
+		if addr, err = net.ResolveUDPAddr("udp", source); err == nil {
+			return []*Contact{{id: RandomID(), ip: addr.IP, port: addr.Port, status: Good, lastSeen: time.Now()}}, nil
+		}
+		if i < config.BootstrapRetries-1 {
+			log.Printf("bootstrap: resolving %s failed, retrying in %v", source, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+		}
+	}
+	return nil, err
+}
+
+// persistedContacts returns contacts from all node snapshots in store.
+func (b *bootstrapper) persistedContacts() ([]*Contact, error) {
+	if b.store == nil {
+		return nil, fmt.Errorf("no node store")
+	}
+	ids, err := b.store.NodeIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []*Contact
+	for _, id := range ids {
+		data, err := b.store.LoadNode(id)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if s, err := decodeSnapshot(data); err == nil {
+			contacts = append(contacts, s.contacts...)
+		}
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("no contacts in persisted tables")
+	}
+	return contacts, nil
+}
+
+// dead checks if c has been queried enough times without ever answering.
+func (b *bootstrapper) dead(c *Contact) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.health[addrKey(c)]
+	return ok && h.answered == 0 && h.queried >= config.BootstrapMaxMisses
+}
+
+// forget drops the health records of cs.
+func (b *bootstrapper) forget(cs []*Contact) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range cs {
+		delete(b.health, addrKey(c))
+	}
+}
+
+// record updates health of bootstrap node c after it has been queried.
+func (b *bootstrapper) record(c *Contact, answered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.health[addrKey(c)]
+	if !ok {
+		h = new(bootstrapHealth)
+		b.health[addrKey(c)] = h
+	}
+	h.queried++
+	if answered {
+		h.answered++
+		h.lastSeen = time.Now()
+	}
+}
+
+func addrKey(c *Contact) string {
+	return net.JoinHostPort(c.ip.String(), fmt.Sprint(c.port))
+}
@@ -0,0 +1,54 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBootstrapSkipsDeadNodes(t *testing.T) {
+	b := newBootstrapper([]string{"127.0.0.1:6881", "127.0.0.2:6881"}, nil)
+	contacts := b.contacts(context.Background())
+	if len(contacts) != 2 {
+		t.Fatalf("expected 2 bootstrap nodes, got: %d nodes", len(contacts))
+	}
+
+	for i := 0; i < config.BootstrapMaxMisses; i++ {
+		b.record(contacts[0], false)
+		b.record(contacts[1], i == 0)
+	}
+
+	contacts = b.contacts(context.Background())
+	if len(contacts) != 1 || contacts[0].ip.String() != "127.0.0.2" {
+		t.Errorf("expected only 127.0.0.2 left, got: %v", contacts)
+	}
+}
+
+func TestBootstrapRetriesAllDead(t *testing.T) {
+	b := newBootstrapper([]string{"127.0.0.1:6881", "127.0.0.2:6881"}, nil)
+	contacts := b.contacts(context.Background())
+	for i := 0; i < config.BootstrapMaxMisses; i++ {
+		for _, c := range contacts {
+			b.record(c, false)
+		}
+	}
+
+	if contacts = b.contacts(context.Background()); len(contacts) != 2 {
+		t.Fatalf("expected all nodes retried when all are dead, got: %d nodes", len(contacts))
+	}
+	if b.dead(contacts[0]) || b.dead(contacts[1]) {
+		t.Errorf("expected health of retried nodes to be reset")
+	}
+}
+
+func TestBootstrapResolveCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := newBootstrapper(nil, nil).resolve(ctx, "invalid.invalid:6881"); err == nil {
+		t.Fatalf("expected an error resolving an invalid host")
+	}
+	if d := time.Since(start); d >= config.BootstrapBackoff {
+		t.Errorf("expected resolve to return without waiting for backoff, took: %v", d)
+	}
+}
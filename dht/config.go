@@ -0,0 +1,161 @@
+package dht
+
+import "time"
+
+// Config holds tunables of a DHT node.
+// TODO: load from a config/JSON file.
+type Config struct {
+	// SubnetBucketLimit is the max number of contacts from the same
+	// /24 (IPv4) or /64 (IPv6) prefix a single bucket may hold.
+	SubnetBucketLimit int
+
+	// SubnetTableLimit is the max number of contacts from the same
+	// prefix the whole routing table may hold.
+	SubnetTableLimit int
+
+	// Bootstrap lists sources of bootstrap nodes, see bootstrap.go.
+	Bootstrap []string
+
+	// BootstrapRetries is the number of attempts to resolve a bootstrap
+	// host, BootstrapBackoff is the wait after the first failed attempt
+	// and doubles after each one.
+	BootstrapRetries int
+	BootstrapBackoff time.Duration
+
+	// BootstrapMaxMisses is the number of unanswered queries after which
+	// a bootstrap node that never answered is skipped.
+	BootstrapMaxMisses int
+
+	// DefaultTimeout is how long to wait for an answer from a node whose
+	// round trip time hasn't been measured, timeouts for measured nodes
+	// adapt to their round trip time but stay within [MinTimeout, MaxTimeout].
+	DefaultTimeout time.Duration
+	MinTimeout     time.Duration
+	MaxTimeout     time.Duration
+
+	// LookupAlpha is the number of requests a lookup keeps in flight
+	// on each path, LookupPaths is the number of disjoint paths of a
+	// lookup and LookupTimeout bounds the time of a background lookup.
+	LookupAlpha   int
+	LookupPaths   int
+	LookupTimeout time.Duration
+
+	// ReannounceInterval is how often registered infohashes are announced
+	// again, it must stay below the 10 minutes other nodes accept tokens.
+	ReannounceInterval time.Duration
+
+	// TraceRingSize is the number of recent lookup traces kept in memory.
+	TraceRingSize int
+
+	// PeerCacheTTL is how long results of get_peers lookups are cached,
+	// PeerCacheSize is the max number of cached infohashes.
+	PeerCacheTTL  time.Duration
+	PeerCacheSize int
+
+	// TokenRotation is how often the secret of announce tokens changes,
+	// a token stays valid for one to two rotations.
+	TokenRotation time.Duration
+
+	// MaxPeersPerInfohash caps peers stored for one infohash, PeerTTL is
+	// how long an announced peer is kept and MaxPeersPerReply caps the
+	// values of a get_peers reply.
+	MaxPeersPerInfohash int
+	PeerTTL             time.Duration
+	MaxPeersPerReply    int
+
+	// Sizes and drop policies of the queues between the stages of a node,
+	// see queue.go. Packets read from the socket wait in the read queue
+	// for decoding, decoded messages wait in the message queue for the
+	// broker, which always blocks so responses are never lost, queries
+	// wait in the dispatch queue for one of DispatchWorkers workers, and
+	// peers and infohashes wait in the storage queue to be saved.
+	ReadQueueSize       int
+	ReadQueuePolicy     int
+	MessageQueueSize    int
+	DispatchQueueSize   int
+	DispatchQueuePolicy int
+	DispatchWorkers     int
+	StorageQueueSize    int
+	StorageQueuePolicy  int
+
+	// InfohashDedupeSize is the number of infohashes the dedupe filter of
+	// InfohashSeen events holds per window at false positive rate
+	// InfohashDedupeRate, a repeat is dropped for one to two windows of
+	// InfohashDedupeWindow.
+	InfohashDedupeSize   int
+	InfohashDedupeRate   float64
+	InfohashDedupeWindow time.Duration
+
+	// SizeEstimateInterval is how often a node runs SizeEstimateLookups
+	// lookups for random targets to estimate the size of the network from
+	// the distances of their closest nodes, the estimate is the mean of
+	// the last SizeSamples samples. Other lookups add no samples, so by
+	// default the estimate covers the last 16 rounds.
+	SizeEstimateInterval time.Duration
+	SizeEstimateLookups  int
+	SizeSamples          int
+}
+
+// config is the configuration used by all nodes in this process.
+var config = defaultConfig()
+
+// DefaultConfig returns the configuration used unless SetConfig is called.
+func DefaultConfig() Config {
+	return *defaultConfig()
+}
+
+// SetConfig replaces the configuration of all nodes in this process,
+// it must be called before the first node is created.
+func SetConfig(c Config) {
+	config = &c
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		SubnetBucketLimit: 2,
+		SubnetTableLimit:  10,
+
+		Bootstrap:          Bootstrappers,
+		BootstrapRetries:   3,
+		BootstrapBackoff:   time.Second,
+		BootstrapMaxMisses: 3,
+
+		DefaultTimeout: 2 * time.Second,
+		MinTimeout:     500 * time.Millisecond,
+		MaxTimeout:     10 * time.Second,
+
+		LookupAlpha:   3,
+		LookupPaths:   1,
+		LookupTimeout: time.Minute,
+
+		ReannounceInterval: 5 * time.Minute,
+
+		TraceRingSize: 256,
+
+		PeerCacheTTL:  2 * time.Minute,
+		PeerCacheSize: 1024,
+
+		TokenRotation: 5 * time.Minute,
+
+		MaxPeersPerInfohash: 100,
+		PeerTTL:             30 * time.Minute,
+		MaxPeersPerReply:    50,
+
+		ReadQueueSize:       1024,
+		ReadQueuePolicy:     DropNewest,
+		MessageQueueSize:    256,
+		DispatchQueueSize:   256,
+		DispatchQueuePolicy: DropOldest,
+		DispatchWorkers:     4,
+		StorageQueueSize:    1024,
+		StorageQueuePolicy:  DropNewest,
+
+		InfohashDedupeSize:   100000,
+		InfohashDedupeRate:   0.001,
+		InfohashDedupeWindow: 10 * time.Minute,
+
+		SizeEstimateInterval: 10 * time.Minute,
+		SizeEstimateLookups:  4,
+		SizeSamples:          64,
+	}
+}
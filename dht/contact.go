@@ -0,0 +1,246 @@
+package dht
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	Good          = iota
+	Questionable1 = iota
+	Questionable2 = iota
+	Bad           = iota
+)
+
+// Contact has all information needed to communicate with a remote node.
+// Contact implements Stringer interface.
+type Contact struct {
+	id       Identifier
+	ip       net.IP
+	port     int
+	status   uint8
+	lastSeen time.Time
+
+	// rtt is the smoothed round trip time and rttVar its variation,
+	// both computed the way TCP does (RFC 6298). failures counts
+	// consecutive requests that timed out. mu guards all three.
+	mu       sync.Mutex
+	rtt      time.Duration
+	rttVar   time.Duration
+	failures int
+}
+
+// NewContact returns a
+func NewContact(id Identifier) *Contact {
+	// conn, _ := net.ListenUDP("udp", nil)
+	// localAddr := conn.LocalAddr().(*net.UDPAddr)
+
+	return &Contact{
+		id:       id,
+		lastSeen: time.Now(),
+	}
+}
+
+// ID returns id of the node c.
+func (c *Contact) ID() Identifier {
+	return c.id
+}
+
+// Addr returns UDP address of the node c.
+func (c *Contact) Addr() *net.UDPAddr {
+	return &net.UDPAddr{IP: c.ip, Port: c.port}
+}
+
+// observeRTT updates round trip time estimation of c with a new sample.
+func (c *Contact) observeRTT(sample time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar = (3*c.rttVar + delta) / 4
+		c.rtt = (7*c.rtt + sample) / 8
+	}
+	c.failures = 0
+	c.status = Good
+	c.lastSeen = time.Now()
+}
+
+// observeTimeout records a request to c that was never answered,
+// a contact turns bad after 3 failures in a row.
+func (c *Contact) observeTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures++
+	switch {
+	case c.failures == 1:
+		c.status = Questionable1
+	case c.failures == 2:
+		c.status = Questionable2
+	default:
+		c.status = Bad
+	}
+}
+
+// isGood checks if c has answered its last requests.
+func (c *Contact) isGood() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status == Good
+}
+
+// estimatedRTT returns smoothed round trip time of c, or the default
+// request timeout if nothing has been measured yet.
+func (c *Contact) estimatedRTT() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rtt == 0 {
+		return config.DefaultTimeout
+	}
+	return c.rtt
+}
+
+// timeout returns how long to wait for an answer from c.
+func (c *Contact) timeout() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rtt == 0 {
+		return config.DefaultTimeout
+	}
+	t := c.rtt + 4*c.rttVar
+	if t < config.MinTimeout {
+		t = config.MinTimeout
+	}
+	if t > config.MaxTimeout {
+		t = config.MaxTimeout
+	}
+	return t
+}
+
+// inherit copies round trip measurements from another contact
+// of the same node.
+func (c *Contact) inherit(other *Contact) {
+	if c == other {
+		return
+	}
+	other.mu.Lock()
+	rtt, rttVar, failures := other.rtt, other.rttVar, other.failures
+	other.mu.Unlock()
+
+	c.mu.Lock()
+	c.rtt, c.rttVar, c.failures = rtt, rttVar, failures
+	c.mu.Unlock()
+}
+
+func (c *Contact) String() string {
+	s := fmt.Sprintf("[id=%s, ip=%s, port=%d, status=%d]",
+		c.id.HexString(), c.ip, c.port, c.status)
+	return s
+}
+
+// Contacts is made up of serveral contacts and a target.
+// Contacts implemented sort.Interface.
+// TODO: this confused even myself.
+type Contacts struct {
+	target      Identifier
+	contactList []*Contact
+}
+
+func (cs *Contacts) print() {
+	for _, c := range cs.contactList {
+		log.Printf("%s", c)
+	}
+}
+
+func (cs *Contacts) Len() int {
+	return len(cs.contactList)
+}
+
+func (cs *Contacts) Less(i, j int) bool {
+	return closer(cs.target, cs.contactList[i], cs.contactList[j])
+}
+
+func (cs *Contacts) Swap(i, j int) {
+	cs.contactList[i], cs.contactList[j] = cs.contactList[j], cs.contactList[i]
+}
+
+// closer orders contacts by distance to target. Contacts which are about
+// equally close, i.e. they'd fall into the same bucket of target, are
+// ordered by round trip time so faster nodes are queried first.
+func closer(target Identifier, a, b *Contact) bool {
+	return closerRTT(target, a, b, a.estimatedRTT(), b.estimatedRTT())
+}
+
+// closerRTT is closer with the round trip times ra of a and rb of b
+// given, sorts use it with times taken before sorting so the order
+// doesn't change while other goroutines measure the contacts.
+func closerRTT(target Identifier, a, b *Contact, ra, rb time.Duration) bool {
+	da := distance(a.id, target)
+	db := distance(b.id, target)
+	if prefixLen(da) == prefixLen(db) && ra != rb {
+		return ra < rb
+	}
+	return bytes.Compare(da, db) < 0
+}
+
+// func (c *Contacts) Encode() []byte {
+// 	b := bytes.NewBuffer(nil)
+// 	for _, c := range c.ContactList {
+
+// 	}
+// }
+
+//TODO: we should these methods rather than functions
+func encodeContacts(nodes []*Contact) []byte {
+	b := bytes.NewBuffer(nil)
+	for _, n := range nodes {
+		encodeContact(b, n)
+	}
+	return b.Bytes()
+}
+
+func encodeContact(b *bytes.Buffer, c *Contact) {
+	b.Write(c.id)
+	encodeAddr(b, c.ip, c.port)
+}
+
+func encodeAddr(b *bytes.Buffer, ip net.IP, port int) {
+	b.Write(ip.To4())
+	b.WriteByte(byte((port & 0xFF00) >> 8))
+	b.WriteByte(byte(port & 0xFF))
+}
+
+// decodeContacts decodes byte slice into a list of node contacts.
+// TODO: There's quite a bit of stupidity here.
+func decodeContacts(data []byte) []*Contact {
+	var contacts []*Contact
+	for j := 0; j < len(data); j = j + 26 {
+		if j+26 > len(data) {
+			break
+		}
+		//TODO: this is stupid
+		kn := data[j : j+26]
+		c := &Contact{
+			id:       Identifier(kn[0:20]),
+			ip:       kn[20:24],
+			port:     int(kn[24:26][0])<<8 + int(kn[24:26][1]),
+			status:   Good,
+			lastSeen: time.Now(),
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts
+}
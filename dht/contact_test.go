@@ -0,0 +1,45 @@
+package dht
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestContactTimeout(t *testing.T) {
+	c := NewContact(RandomID())
+	if c.timeout() != config.DefaultTimeout {
+		t.Errorf("expected timeout %v, got: %v", config.DefaultTimeout, c.timeout())
+	}
+
+	for i := 0; i < 10; i++ {
+		c.observeRTT(100 * time.Millisecond)
+	}
+	if c.timeout() >= config.DefaultTimeout {
+		t.Errorf("expected timeout below %v, got: %v", config.DefaultTimeout, c.timeout())
+	}
+
+	for i := 0; i < 3; i++ {
+		c.observeTimeout()
+	}
+	if c.isGood() {
+		t.Errorf("expected contact to be bad after 3 timeouts")
+	}
+}
+
+func TestContactsPreferFast(t *testing.T) {
+	target := HexToID("0000000000000000000000000000000000000000")
+	slow := NewContact(HexToID("0000000000000000000000000000000000000011"))
+	fast := NewContact(HexToID("0000000000000000000000000000000000000012"))
+	far := NewContact(HexToID("0000000000000000000000000000000000001000"))
+	slow.observeRTT(time.Second)
+	fast.observeRTT(10 * time.Millisecond)
+	far.observeRTT(time.Millisecond)
+
+	cs := &Contacts{target: target, contactList: []*Contact{far, slow, fast}}
+	sort.Sort(cs)
+
+	if cs.contactList[0] != fast || cs.contactList[1] != slow || cs.contactList[2] != far {
+		t.Errorf("expected order fast, slow, far, got: %v", cs.contactList)
+	}
+}
@@ -0,0 +1,345 @@
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of crawler identities.
+const (
+	// SpreadIdentity is one of the identities spread evenly over
+	// the id space, they run as long as the crawler.
+	SpreadIdentity = "spread"
+	// TargetIdentity is a short lived identity next to an infohash,
+	// other nodes send it the announce_peer queries of the infohash.
+	TargetIdentity = "target"
+)
+
+// CrawlerConfig configures a Crawler.
+type CrawlerConfig struct {
+	// Spread is the number of identities spread evenly over the id space.
+	Spread int
+
+	// MaxIdentities caps the number of identities running at once, an
+	// expired target identity makes room for a new one, otherwise the new
+	// target is dropped.
+	MaxIdentities int
+
+	// TargetTTL is how long a target identity runs.
+	TargetTTL time.Duration
+
+	// TargetInterval is the minimum time between starting two target
+	// identities, targets in between are dropped. Zero means no limit.
+	TargetInterval time.Duration
+
+	// FollowInfohashes places a target identity next to every infohash
+	// which other nodes ask the identities for.
+	FollowInfohashes bool
+}
+
+// identity is a node run by a crawler.
+type identity struct {
+	node    *Node
+	kind    string
+	target  Identifier
+	started time.Time
+	expires time.Time
+
+	infohashes uint64
+	announces  uint64
+}
+
+// IdentityStats describes the yield of a crawler identity.
+type IdentityStats struct {
+	ID         string        `json:"id"`
+	Kind       string        `json:"kind"`
+	Target     string        `json:"target,omitempty"`
+	Age        time.Duration `json:"age"`
+	Contacts   int           `json:"contacts"`
+	Infohashes uint64        `json:"infohashes"`
+	Announces  uint64        `json:"announces"`
+
+	// PerHour is the number of infohashes seen per hour of age.
+	PerHour float64 `json:"per_hour"`
+}
+
+// Crawler runs many nodes with different ids to see a bigger part of the
+// DHT traffic than a single node. Some identities are spread evenly over
+// the id space, others are placed next to infohashes for a while to catch
+// announce_peer queries sent to the nodes closest to them.
+type Crawler struct {
+	cfg  CrawlerConfig
+	opts []Option
+
+	mu         sync.Mutex
+	identities map[string]*identity
+	lastTarget time.Time
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewCrawler returns a crawler whose identities are configured by opts,
+// opts must not set an id or a fixed port since every identity has its own.
+func NewCrawler(cfg CrawlerConfig, opts ...Option) *Crawler {
+	if cfg.MaxIdentities < cfg.Spread {
+		cfg.MaxIdentities = cfg.Spread
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Crawler{
+		cfg:        cfg,
+		opts:       opts,
+		identities: make(map[string]*identity),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// spreadIDs returns n ids spread evenly over the id space, each at a
+// random position within its 1/n of the space.
+func spreadIDs(n int) []Identifier {
+	if n <= 0 {
+		return nil
+	}
+	space := new(big.Int).Lsh(big.NewInt(1), 160)
+	slot := new(big.Int).Div(space, big.NewInt(int64(n)))
+	ids := make([]Identifier, n)
+	for i := range ids {
+		offset, _ := rand.Int(rand.Reader, slot)
+		x := new(big.Int).Mul(slot, big.NewInt(int64(i)))
+		x.Add(x, offset)
+		ids[i] = make(Identifier, 20)
+		x.FillBytes(ids[i])
+	}
+	return ids
+}
+
+// nearID returns a random id which shares all but the last 16 bits with
+// target, so it is among the closest nodes of target.
+func nearID(target Identifier) Identifier {
+	id := append(Identifier(nil), target...)
+	for string(id) == string(target) {
+		rand.Read(id[18:])
+	}
+	return id
+}
+
+// Start starts the spread identities and expires target identities until
+// ctx is done or Stop is called. Identities keep running until Stop.
+func (c *Crawler) Start(ctx context.Context) error {
+	for _, id := range spreadIDs(c.cfg.Spread) {
+		if err := c.add(id, SpreadIdentity, nil); err != nil {
+			return err
+		}
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.expire()
+			case <-ctx.Done():
+				return
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops all identities.
+func (c *Crawler) Stop() {
+	c.cancel()
+	c.wg.Wait()
+
+	c.mu.Lock()
+	identities := c.identities
+	c.identities = make(map[string]*identity)
+	c.mu.Unlock()
+	for _, i := range identities {
+		i.node.Stop()
+	}
+}
+
+// Target places an identity next to infohash for TargetTTL. The target is
+// dropped if the crawler runs MaxIdentities identities and none of them
+// has expired, or if the last target started within TargetInterval.
+// Targeting an infohash again extends the life of its identity.
+func (c *Crawler) Target(infohash Identifier) error {
+	return c.add(nearID(infohash), TargetIdentity, infohash)
+}
+
+// add starts a node with id. A target identity learns the contacts
+// closest to its id from the running identities and looks up its own id,
+// so the nodes around it learn about it. The check for an identity of
+// target and the reservation of its slot happen under c.mu, so concurrent
+// calls for one target start a single identity.
+func (c *Crawler) add(id Identifier, kind string, target Identifier) error {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		return c.ctx.Err()
+	}
+	if kind == TargetIdentity {
+		for _, i := range c.identities {
+			if i.kind == TargetIdentity && string(i.target) == string(target) {
+				i.expires = time.Now().Add(c.cfg.TargetTTL)
+				c.mu.Unlock()
+				return nil
+			}
+		}
+		if c.cfg.TargetInterval > 0 && time.Since(c.lastTarget) < c.cfg.TargetInterval {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	var evicted *identity
+	if len(c.identities) >= c.cfg.MaxIdentities {
+		if evicted = c.expiredTarget(); evicted == nil {
+			c.mu.Unlock()
+			return nil
+		}
+		delete(c.identities, evicted.node.info.id.HexString())
+	}
+
+	i := &identity{kind: kind, target: target, started: time.Now(), expires: time.Now().Add(c.cfg.TargetTTL)}
+	opts := append(append([]Option(nil), c.opts...), WithID(id), WithInfohashHook(func(e InfohashSeen) {
+		atomic.AddUint64(&i.infohashes, 1)
+		if e.Method == "announce_peer" {
+			atomic.AddUint64(&i.announces, 1)
+		}
+		if c.cfg.FollowInfohashes && e.Method == "get_peers" {
+			if err := c.Target(e.Infohash); err != nil {
+				log.Printf("error occurred while targeting %s: %v", e.Infohash.HexString(), err)
+			}
+		}
+	}))
+	node, err := NewNode(opts...)
+	if err != nil {
+		if evicted != nil {
+			c.identities[evicted.node.info.id.HexString()] = evicted
+		}
+		c.mu.Unlock()
+		return err
+	}
+	i.node = node
+
+	var seeds []*Contact
+	if kind == TargetIdentity {
+		for _, other := range c.identities {
+			seeds = append(seeds, other.node.table.findLocalClosest(id)...)
+		}
+	}
+	c.identities[id.HexString()] = i
+	if kind == TargetIdentity {
+		c.lastTarget = i.started
+	}
+	c.mu.Unlock()
+
+	if evicted != nil {
+		log.Printf("crawler: stopping expired identity %s to make room", evicted.node.info.id.HexString())
+		go evicted.node.Stop()
+	}
+	for _, s := range seeds {
+		node.table.insertNode(&Contact{id: s.id, ip: s.ip, port: s.port, status: s.status, lastSeen: s.lastSeen})
+	}
+	node.Start(c.ctx)
+	if len(seeds) > 0 {
+		node.goroutine(func() { node.searchNodes(id) })
+	}
+	log.Printf("crawler: started %s identity %s", kind, id.HexString())
+	return nil
+}
+
+// expiredTarget returns the target identity which expired first, or nil
+// if none has expired, c.mu must be held.
+func (c *Crawler) expiredTarget() *identity {
+	var oldest *identity
+	now := time.Now()
+	for _, i := range c.identities {
+		if i.kind == TargetIdentity && now.After(i.expires) && (oldest == nil || i.expires.Before(oldest.expires)) {
+			oldest = i
+		}
+	}
+	return oldest
+}
+
+// expire stops target identities whose time is up.
+func (c *Crawler) expire() {
+	var expired []*identity
+	c.mu.Lock()
+	for key, i := range c.identities {
+		if i.kind == TargetIdentity && time.Now().After(i.expires) {
+			expired = append(expired, i)
+			delete(c.identities, key)
+		}
+	}
+	c.mu.Unlock()
+	for _, i := range expired {
+		i.node.Stop()
+	}
+}
+
+// Nodes returns the nodes of all running identities, spread identities
+// first.
+func (c *Crawler) Nodes() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var nodes []*Node
+	for _, i := range c.sorted() {
+		nodes = append(nodes, i.node)
+	}
+	return nodes
+}
+
+// Stats returns yield of all running identities, spread identities first.
+func (c *Crawler) Stats() []IdentityStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stats []IdentityStats
+	for _, i := range c.sorted() {
+		s := IdentityStats{
+			ID:         i.node.info.id.HexString(),
+			Kind:       i.kind,
+			Age:        time.Since(i.started),
+			Contacts:   i.node.table.size(),
+			Infohashes: atomic.LoadUint64(&i.infohashes),
+			Announces:  atomic.LoadUint64(&i.announces),
+		}
+		if i.target != nil {
+			s.Target = i.target.HexString()
+		}
+		if hours := s.Age.Hours(); hours > 0 {
+			s.PerHour = float64(s.Infohashes) / hours
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// sorted returns running identities, spread ones first and each kind
+// by start time, c.mu must be held.
+func (c *Crawler) sorted() []*identity {
+	var identities []*identity
+	for _, i := range c.identities {
+		identities = append(identities, i)
+	}
+	sort.Slice(identities, func(a, b int) bool {
+		if identities[a].kind != identities[b].kind {
+			return identities[a].kind == SpreadIdentity
+		}
+		return identities[a].started.Before(identities[b].started)
+	})
+	return identities
+}
@@ -0,0 +1,124 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpreadIDs(t *testing.T) {
+	ids := spreadIDs(16)
+	slot := new(big.Int).Lsh(big.NewInt(1), 156)
+	for i, id := range ids {
+		if len(id) != 20 {
+			t.Fatalf("expected 20 byte id, got: %d bytes", len(id))
+		}
+		if n := new(big.Int).Div(id.toInt(), slot); n.Int64() != int64(i) {
+			t.Errorf("expected id %d in slot %d, got: slot %d", i, i, n)
+		}
+	}
+}
+
+func TestSpreadIDsNone(t *testing.T) {
+	if ids := spreadIDs(0); len(ids) != 0 {
+		t.Errorf("expected no ids, got: %d ids", len(ids))
+	}
+	c := NewCrawler(CrawlerConfig{})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+}
+
+func TestNearID(t *testing.T) {
+	target := RandomID()
+	id := nearID(target)
+	if bytes.Equal(id, target) {
+		t.Fatalf("expected an id other than the target")
+	}
+	if n := prefixLen(distance(id, target)); n < 144 {
+		t.Errorf("expected at least 144 common bits, got: %d bits", n)
+	}
+}
+
+func TestCrawlerIdentities(t *testing.T) {
+	c := NewCrawler(CrawlerConfig{Spread: 2, MaxIdentities: 3, TargetTTL: time.Minute},
+		WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap())
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	first, second := RandomID(), RandomID()
+	for _, ih := range []Identifier{first, first, second} {
+		if err := c.Target(ih); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := c.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 identities, got: %d identities", len(stats))
+	}
+	if stats[0].Kind != SpreadIdentity || stats[1].Kind != SpreadIdentity {
+		t.Errorf("expected spread identities first, got: %+v", stats)
+	}
+	if stats[2].Kind != TargetIdentity || stats[2].Target != first.HexString() {
+		t.Errorf("expected %s to be dropped while %s runs, got: %+v", second.HexString(), first.HexString(), stats[2])
+	}
+
+	c.mu.Lock()
+	for _, i := range c.identities {
+		if i.kind == TargetIdentity {
+			i.expires = time.Now().Add(-time.Second)
+		}
+	}
+	c.mu.Unlock()
+	if err := c.Target(second); err != nil {
+		t.Fatal(err)
+	}
+	if stats = c.Stats(); len(stats) != 3 || stats[2].Target != second.HexString() {
+		t.Errorf("expected the expired target to make room for %s, got: %+v", second.HexString(), stats)
+	}
+}
+
+func TestCrawlerTargetInterval(t *testing.T) {
+	c := NewCrawler(CrawlerConfig{MaxIdentities: 10, TargetTTL: time.Minute, TargetInterval: time.Hour},
+		WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap())
+	defer c.Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := c.Target(RandomID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := c.Stats(); len(stats) != 1 {
+		t.Errorf("expected 1 identity within the interval, got: %d identities", len(stats))
+	}
+}
+
+func TestCrawlerTargetOnce(t *testing.T) {
+	c := NewCrawler(CrawlerConfig{MaxIdentities: 10, TargetTTL: time.Minute},
+		WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap())
+	defer c.Stop()
+
+	ih := RandomID()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Target(ih); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := c.Stats(); len(stats) != 1 {
+		t.Errorf("expected 1 identity for %s, got: %+v", ih.HexString(), stats)
+	}
+}
@@ -0,0 +1,126 @@
+package dht
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// Formats of DHT state files written by other clients. Both keep compact
+// endpoints (ip + port) of known nodes but not their ids.
+//
+// libtorrent keeps {"node-id": id, "nodes": [endpoint], "nodes6": [endpoint]}
+// under the "dht state" key of its session state, Transmission's dht.dat is
+// {"id": id, "nodes": endpoints, "nodes6": endpoints} with all endpoints of
+// one family concatenated into a single string.
+const (
+	StateLibtorrent   = "libtorrent"
+	StateTransmission = "transmission"
+)
+
+// ImportState decodes a libtorrent or Transmission DHT state file and
+// returns the nodes in it as bootstrap contacts, the format is detected
+// from the keys present.
+func ImportState(r io.Reader) ([]*Contact, error) {
+	v := make(map[string]interface{})
+	if err := bencode.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	if s, ok := v["dht state"].(map[string]interface{}); ok {
+		v = s
+	}
+
+	var contacts []*Contact
+	for key, size := range map[string]int{"nodes": 6, "nodes6": 18} {
+		switch nodes := v[key].(type) {
+		case []interface{}: // libtorrent
+			for _, n := range nodes {
+				if s, ok := n.(string); ok {
+					contacts = append(contacts, decodeEndpoints([]byte(s), size)...)
+				}
+			}
+		case string: // Transmission
+			contacts = append(contacts, decodeEndpoints([]byte(nodes), size)...)
+		}
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("no nodes found in DHT state")
+	}
+	return contacts, nil
+}
+
+// ExportState encodes contacts and id into a DHT state file of given format.
+func ExportState(w io.Writer, format string, id Identifier, contacts []*Contact) error {
+	var nodes, nodes6 [][]byte
+	for _, c := range contacts {
+		b := bytes.NewBuffer(nil)
+		encodeEndpoint(b, c.ip, c.port)
+		if c.ip.To4() != nil {
+			nodes = append(nodes, b.Bytes())
+		} else {
+			nodes6 = append(nodes6, b.Bytes())
+		}
+	}
+
+	var v map[string]interface{}
+	switch format {
+	case StateLibtorrent:
+		v = map[string]interface{}{
+			"dht state": map[string]interface{}{
+				"node-id": id.String(),
+				"nodes":   toStrings(nodes),
+				"nodes6":  toStrings(nodes6),
+			},
+		}
+	case StateTransmission:
+		v = map[string]interface{}{
+			"id":     id.String(),
+			"nodes":  string(bytes.Join(nodes, nil)),
+			"nodes6": string(bytes.Join(nodes6, nil)),
+		}
+	default:
+		return fmt.Errorf("unknown DHT state format %q", format)
+	}
+	return bencode.NewEncoder(w).Encode(v)
+}
+
+func toStrings(bs [][]byte) []string {
+	s := make([]string, len(bs))
+	for i, b := range bs {
+		s[i] = string(b)
+	}
+	return s
+}
+
+// encodeEndpoint writes ip and port in compact format, 6 bytes
+// for IPv4 and 18 bytes for IPv6 addresses.
+func encodeEndpoint(b *bytes.Buffer, ip net.IP, port int) {
+	if ip.To4() != nil {
+		encodeAddr(b, ip, port)
+		return
+	}
+	b.Write(ip.To16())
+	b.WriteByte(byte((port & 0xFF00) >> 8))
+	b.WriteByte(byte(port & 0xFF))
+}
+
+// decodeEndpoints decodes a run of compact endpoints of size bytes each
+// into contacts with random ids.
+func decodeEndpoints(data []byte, size int) []*Contact {
+	var contacts []*Contact
+	for j := 0; j+size <= len(data); j += size {
+		ep := data[j : j+size]
+		contacts = append(contacts, &Contact{
+			id:       RandomID(),
+			ip:       net.IP(ep[:size-2]),
+			port:     int(ep[size-2])<<8 + int(ep[size-1]),
+			status:   Good,
+			lastSeen: time.Now(),
+		})
+	}
+	return contacts
+}
@@ -0,0 +1,55 @@
+package dht
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	var contacts []*Contact
+	for _, s := range []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"} {
+		c := NewContact(RandomID())
+		c.ip = net.ParseIP(s)
+		c.port = 6881
+		contacts = append(contacts, c)
+	}
+
+	for _, format := range []string{StateLibtorrent, StateTransmission} {
+		buf := bytes.NewBuffer(nil)
+		if err := ExportState(buf, format, RandomID(), contacts); err != nil {
+			t.Fatalf("expected no error exporting %s, got: %v", format, err)
+		}
+
+		imported, err := ImportState(buf)
+		if err != nil {
+			t.Fatalf("expected no error importing %s, got: %v", format, err)
+		}
+		if len(imported) != len(contacts) {
+			t.Fatalf("expected %d %s nodes, got: %d nodes", len(contacts), format, len(imported))
+		}
+		for _, c := range contacts {
+			found := false
+			for _, i := range imported {
+				found = found || (i.ip.Equal(c.ip) && i.port == c.port)
+			}
+			if !found {
+				t.Errorf("expected %s:%d in %s state", c.ip, c.port, format)
+			}
+		}
+	}
+}
+
+func TestImportLibtorrentState(t *testing.T) {
+	// {"dht state": {"node-id": ..., "nodes": ["\x7f\x00\x00\x01\x1a\xe1"]}}
+	state := "d9:dht stated7:node-id20:aaaaaaaaaaaaaaaaaaaa5:nodesl6:\x7f\x00\x00\x01\x1a\xe1eee"
+
+	contacts, err := ImportState(strings.NewReader(state))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(contacts) != 1 || !contacts[0].ip.Equal(net.IPv4(127, 0, 0, 1)) || contacts[0].port != 6881 {
+		t.Errorf("expected 127.0.0.1:6881, got: %v", contacts)
+	}
+}
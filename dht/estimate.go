@@ -0,0 +1,227 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"expvar"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SizeEstimate is an estimate of the number of nodes in the DHT.
+type SizeEstimate struct {
+	// Nodes is the inverse of the mean density of the samples, Low and
+	// High bound its 95% confidence interval. High is 0 if there are too
+	// few samples to bound it.
+	Nodes float64 `json:"nodes"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+
+	Samples int       `json:"samples"`
+	Updated time.Time `json:"updated"`
+}
+
+// sizeSample estimates the number of nodes from the closest nodes of a
+// lookup. Ids are uniform, so the i-th closest node is expected at
+// distance i/N of the id space. The least squares fit of d_i = i/N over
+// the distances d_i of the closest nodes gives N = Σi² / Σ(i·d_i).
+// Closest of a lookup puts faster nodes first among nodes of a bucket,
+// so the distances are sorted here.
+func sizeSample(result *LookupResult) (float64, bool) {
+	if len(result.Closest) < 2 {
+		return 0, false
+	}
+	ds := make([]float64, len(result.Closest))
+	for i, c := range result.Closest {
+		// the first 64 bits of a distance are plenty for a fraction of the space
+		ds[i] = float64(binary.BigEndian.Uint64(distance(c.id, result.Target))) / math.Exp2(64)
+	}
+	sort.Float64s(ds)
+
+	var num, den float64
+	for i, d := range ds {
+		num += float64((i + 1) * (i + 1))
+		den += float64(i+1) * d
+	}
+	if den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+// sizeEstimator keeps the most recent samples of the network size as
+// densities 1/N. The mean of N is biased upwards by the samples whose
+// closest nodes happen to be very close, the mean of 1/N isn't.
+type sizeEstimator struct {
+	mu        sync.Mutex
+	densities []float64
+	next      int
+	max       int
+	updated   time.Time
+}
+
+func newSizeEstimator(max int) *sizeEstimator {
+	return &sizeEstimator{max: max}
+}
+
+// add adds a sample from result of a lookup for a random target.
+func (e *sizeEstimator) add(result *LookupResult) {
+	n, ok := sizeSample(result)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.densities) < e.max {
+		e.densities = append(e.densities, 1/n)
+	} else {
+		e.densities[e.next] = 1 / n
+		e.next = (e.next + 1) % e.max
+	}
+	e.updated = time.Now()
+}
+
+// estimate returns the inverse of the mean density of the samples. Its
+// confidence interval is the inverse of mean ± 1.96·sd/√n of the densities.
+func (e *sizeEstimator) estimate() SizeEstimate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := SizeEstimate{Samples: len(e.densities), Updated: e.updated}
+	if s.Samples == 0 {
+		return s
+	}
+	var mean float64
+	for _, x := range e.densities {
+		mean += x
+	}
+	mean /= float64(s.Samples)
+	s.Nodes = 1 / mean
+	s.Low, s.High = s.Nodes, s.Nodes
+	if s.Samples > 1 {
+		var v float64
+		for _, x := range e.densities {
+			v += (x - mean) * (x - mean)
+		}
+		sd := math.Sqrt(v / float64(s.Samples-1))
+		margin := 1.96 * sd / math.Sqrt(float64(s.Samples))
+		s.Low, s.High = 1/(mean+margin), 0
+		if mean > margin {
+			s.High = 1 / (mean - margin)
+		}
+	}
+	return s
+}
+
+// NetworkSize returns the estimated number of nodes in the DHT, see
+// SizeEstimateInterval.
+func (node *Node) NetworkSize() SizeEstimate {
+	return node.sizes.estimate()
+}
+
+// estimateSize runs SizeEstimateLookups lookups for random targets
+// every SizeEstimateInterval, each one adds a sample of the network size.
+// Only these lookups add samples, so the two settings control the rate.
+func (node *Node) estimateSize() {
+	ticker := time.NewTicker(config.SizeEstimateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for i := 0; i < config.SizeEstimateLookups && node.ctx.Err() == nil; i++ {
+				node.sampleSize(RandomID())
+			}
+			e := node.NetworkSize()
+			log.Printf("estimated %.0f nodes in network (%.0f-%.0f) from %d samples", e.Nodes, e.Low, e.High, e.Samples)
+		case <-node.ctx.Done():
+			return
+		}
+	}
+}
+
+// sampleSize looks up target and adds a sample from its closest nodes,
+// nodes which answered are added to routing table.
+func (node *Node) sampleSize(target Identifier) {
+	ctx, cancel := context.WithTimeout(node.ctx, config.LookupTimeout)
+	defer cancel()
+
+	result, err := node.FindNode(ctx, target)
+	if err != nil {
+		log.Printf("error occurred while sampling network size at %s: %v", target.HexString(), err)
+		return
+	}
+	node.sizes.add(result)
+}
+
+// NodeStatus describes a running node.
+type NodeStatus struct {
+	ID          string       `json:"id"`
+	Addr        string       `json:"addr"`
+	Contacts    int          `json:"contacts"`
+	NetworkSize SizeEstimate `json:"network_size"`
+}
+
+// Status describes the running nodes of this process. Contacts counts
+// distinct contacts of all nodes, NetworkSize is the estimate with the
+// most samples and Coverage is the fraction of the network the contacts
+// make up, CoverageLow and CoverageHigh follow from the confidence
+// interval of NetworkSize.
+type Status struct {
+	Nodes        []NodeStatus `json:"nodes"`
+	Contacts     int          `json:"contacts"`
+	NetworkSize  SizeEstimate `json:"network_size"`
+	Coverage     float64      `json:"coverage"`
+	CoverageLow  float64      `json:"coverage_low"`
+	CoverageHigh float64      `json:"coverage_high"`
+}
+
+// CurrentStatus returns the status of the running nodes.
+func CurrentStatus() Status {
+	running.Lock()
+	var nodes []*Node
+	for node := range running.nodes {
+		nodes = append(nodes, node)
+	}
+	running.Unlock()
+
+	var s Status
+	contacts := make(map[string]bool)
+	for _, node := range nodes {
+		n := NodeStatus{
+			ID:          node.info.id.HexString(),
+			Addr:        node.Addr().String(),
+			Contacts:    node.table.size(),
+			NetworkSize: node.NetworkSize(),
+		}
+		for _, c := range node.Contacts() {
+			contacts[string(c.id)] = true
+		}
+		if n.NetworkSize.Samples > s.NetworkSize.Samples {
+			s.NetworkSize = n.NetworkSize
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	s.Contacts = len(contacts)
+	if e := s.NetworkSize; e.Nodes > 0 {
+		s.Coverage = float64(s.Contacts) / e.Nodes
+		if e.High > 0 {
+			s.CoverageLow = float64(s.Contacts) / e.High
+		}
+		s.CoverageHigh = math.Min(float64(s.Contacts)/e.Low, 1)
+	}
+	return s
+}
+
+func init() {
+	expvar.Publish("dht_network_size", expvar.Func(func() interface{} {
+		running.Lock()
+		defer running.Unlock()
+		sizes := make(map[string]SizeEstimate)
+		for node := range running.nodes {
+			sizes[node.info.id.HexString()] = node.NetworkSize()
+		}
+		return sizes
+	}))
+}
@@ -0,0 +1,73 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// closestOf returns the k ids closest to target as a lookup result.
+func closestOf(ids []Identifier, target Identifier, k int) *LookupResult {
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(distance(ids[i], target), distance(ids[j], target)) < 0
+	})
+	result := &LookupResult{Target: target}
+	for _, id := range ids[:k] {
+		result.Closest = append(result.Closest, NewContact(id))
+	}
+	return result
+}
+
+func TestSizeEstimate(t *testing.T) {
+	const n = 5000
+	ids := make([]Identifier, n)
+	for i := range ids {
+		ids[i] = make(Identifier, 20)
+		rand.Read(ids[i])
+	}
+
+	e := newSizeEstimator(64)
+	if s := e.estimate(); s.Samples != 0 || s.Nodes != 0 {
+		t.Fatalf("expected no estimate without samples, got: %+v", s)
+	}
+	for i := 0; i < 80; i++ {
+		e.add(closestOf(ids, RandomID(), maxNodesPerBucket))
+	}
+
+	s := e.estimate()
+	if s.Samples != 64 {
+		t.Errorf("expected 64 samples, got: %d samples", s.Samples)
+	}
+	if s.Nodes < 0.8*n || s.Nodes > 1.2*n {
+		t.Errorf("expected about %d nodes, got: %+v", n, s)
+	}
+	if !(s.Low < s.Nodes && s.Nodes < s.High) || s.High-s.Low > s.Nodes {
+		t.Errorf("expected a narrow confidence interval around the estimate, got: %+v", s)
+	}
+}
+
+func TestSizeSampleOrder(t *testing.T) {
+	// all contacts fall into one bucket of target, the farther
+	// ones answer faster so a lookup lists them first
+	target := make(Identifier, 20)
+	sorted := &LookupResult{Target: target}
+	for i := 0; i < maxNodesPerBucket; i++ {
+		id := make(Identifier, 20)
+		id[0], id[1] = 0x80, byte(16*(i+1))
+		c := NewContact(id)
+		c.observeRTT(time.Duration(maxNodesPerBucket-i) * time.Millisecond)
+		sorted.Closest = append(sorted.Closest, c)
+	}
+	byRTT := &LookupResult{Target: target, Closest: append([]*Contact(nil), sorted.Closest...)}
+	sort.Slice(byRTT.Closest, func(i, j int) bool { return closer(target, byRTT.Closest[i], byRTT.Closest[j]) })
+	if byRTT.Closest[0] == sorted.Closest[0] {
+		t.Fatalf("expected the fastest contact first")
+	}
+
+	want, _ := sizeSample(sorted)
+	if got, ok := sizeSample(byRTT); !ok || got != want {
+		t.Errorf("expected sample %f regardless of order, got: %f", want, got)
+	}
+}
@@ -0,0 +1,112 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of lookup events.
+const (
+	NodeDiscovered = iota
+	NodeResponded
+	PeerFound
+	LookupDone
+)
+
+// LookupEvent reports progress of a lookup as it happens.
+type LookupEvent struct {
+	Kind int
+
+	// Contact is set for NodeDiscovered and NodeResponded events,
+	// Peer is the address of a PeerFound event.
+	Contact *Contact
+	Peer    *net.UDPAddr
+
+	// Result and Err are set for the final LookupDone event.
+	Result *LookupResult
+	Err    error
+}
+
+// FindNodeEvents runs a find_node lookup for target in background and
+// streams its events, the channel is closed after the LookupDone event.
+func (node *Node) FindNodeEvents(ctx context.Context, target Identifier) <-chan LookupEvent {
+	return node.streamLookup(ctx, newLookup(node, target, "find_node"))
+}
+
+// GetPeersEvents is like FindNodeEvents but runs a get_peers lookup for
+// infohash, found peers are also saved like GetPeers does. Like GetPeers it
+// goes through the result cache, a cached or shared result is streamed as
+// PeerFound events followed by LookupDone.
+func (node *Node) GetPeersEvents(ctx context.Context, infohash Identifier) <-chan LookupEvent {
+	events := make(chan LookupEvent, maxNodesPerBucket)
+
+	// the lookup may outlive this call when ctx is done first,
+	// closed keeps it from sending to the closed channel
+	var mu sync.Mutex
+	closed := false
+	send := func(e LookupEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		var live int32
+		result, err := node.peerResults.get(ctx, infohash.HexString(), func(lctx context.Context) (*LookupResult, error) {
+			atomic.StoreInt32(&live, 1)
+			l := node.newGetPeersLookup(infohash)
+			l.on(func(e LookupEvent) {
+				if e.Kind != LookupDone {
+					send(e)
+				}
+			})
+			return node.runLookup(lctx, l)
+		})
+		if atomic.LoadInt32(&live) == 0 && result != nil {
+			for _, peer := range result.Peers {
+				send(LookupEvent{Kind: PeerFound, Peer: peer})
+			}
+		}
+		send(LookupEvent{Kind: LookupDone, Result: result, Err: err})
+
+		mu.Lock()
+		closed = true
+		close(events)
+		mu.Unlock()
+	}()
+	return events
+}
+
+func (node *Node) streamLookup(ctx context.Context, l *lookup) <-chan LookupEvent {
+	events := make(chan LookupEvent, maxNodesPerBucket)
+	l.on(func(e LookupEvent) {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+	})
+	go func() {
+		defer close(events)
+		node.runLookup(ctx, l)
+	}()
+	return events
+}
+
+// peerAddr decodes a compact peer address.
+func peerAddr(peer string) *net.UDPAddr {
+	n := len(peer) - 2
+	if n != 4 && n != 16 {
+		return nil
+	}
+	ip := make(net.IP, n)
+	copy(ip, peer[:n])
+	return &net.UDPAddr{IP: ip, Port: int(peer[n])<<8 + int(peer[n+1])}
+}
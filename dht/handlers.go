@@ -0,0 +1,128 @@
+package dht
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// KRPC error codes of BEP 5.
+const (
+	GenericError  = 201
+	ServerError   = 202
+	ProtocolError = 203
+	MethodUnknown = 204
+)
+
+// KRPCError is an error which is sent back to the querying node.
+type KRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *KRPCError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// A QueryHandler answers queries of one method. args holds arguments of
+// the query sent from addr. The returned values are sent back as the
+// reply, id of the node is added to them. A *KRPCError is sent back as
+// is, other errors as a server error. Returning nil values and a nil
+// error sends no reply.
+type QueryHandler func(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error)
+
+// handlers holds the query handlers of a node by method name.
+type handlers struct {
+	mu       sync.RWMutex
+	byMethod map[string]QueryHandler
+}
+
+// newHandlers returns handlers for the queries of BEP 5.
+func newHandlers() *handlers {
+	return &handlers{byMethod: map[string]QueryHandler{
+		"ping":          handlePing,
+		"find_node":     handleFindNode,
+		"get_peers":     handleGetPeers,
+		"announce_peer": handleAnnouncePeer,
+	}}
+}
+
+func (h *handlers) get(method string) QueryHandler {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.byMethod[method]
+}
+
+func (h *handlers) set(method string, handler QueryHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if handler == nil {
+		delete(h.byMethod, method)
+		return
+	}
+	h.byMethod[method] = handler
+}
+
+// Handle registers handler for queries of method, it replaces the
+// handler registered before. A nil handler unregisters method, the node
+// answers queries of unknown methods with a 204 error.
+func (node *Node) Handle(method string, handler QueryHandler) {
+	node.handlers.set(method, handler)
+}
+
+func handlePing(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func handleFindNode(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+	target, ok := args["target"].(string)
+	if !ok || len(target) != 20 {
+		return nil, &KRPCError{ProtocolError, "invalid target"}
+	}
+	closest := node.table.findLocalClosest(Identifier(target))
+	return map[string]interface{}{"nodes": string(encodeContacts(closest))}, nil
+}
+
+func handleGetPeers(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+	infohash, ok := args["info_hash"].(string)
+	if !ok || len(infohash) != 20 {
+		return nil, &KRPCError{ProtocolError, "invalid info_hash"}
+	}
+	ih := Identifier(infohash)
+	node.infohashSeen(newInfohashSeen("get_peers", ih, addr, args, 0))
+
+	r := map[string]interface{}{"token": node.tokens.generate(addr.IP)}
+	peers, err := node.peers.Get(ih, config.MaxPeersPerReply)
+	if err != nil {
+		log.Printf("error occurred while loading peers of %s: %v", ih.HexString(), err)
+	}
+	if len(peers) > 0 {
+		r["values"] = compactPeers(peers)
+	} else {
+		r["nodes"] = string(encodeContacts(node.table.findLocalClosest(ih)))
+	}
+	return r, nil
+}
+
+func handleAnnouncePeer(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+	infohash, ok := args["info_hash"].(string)
+	if !ok || len(infohash) != 20 {
+		return nil, &KRPCError{ProtocolError, "invalid info_hash"}
+	}
+	token, ok := args["token"].(string)
+	if !ok || !node.tokens.validate(token, addr.IP) {
+		return nil, &KRPCError{ProtocolError, "bad token"}
+	}
+	port, _ := args["port"].(int64)
+	if impliedPort, ok := args["implied_port"].(int64); ok && impliedPort > 0 {
+		port = int64(addr.Port)
+	}
+	if port <= 0 || port > 65535 {
+		return nil, &KRPCError{ProtocolError, "invalid port"}
+	}
+
+	node.infohashSeen(newInfohashSeen("announce_peer", Identifier(infohash), addr, args, int(port)))
+	node.addPeer(Identifier(infohash), &net.UDPAddr{IP: addr.IP, Port: int(port)})
+	return map[string]interface{}{}, nil
+}
@@ -0,0 +1,121 @@
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// eventNames are the names of lookup events in HTTP responses.
+var eventNames = map[int]string{
+	NodeDiscovered: "node_discovered",
+	NodeResponded:  "node_responded",
+	PeerFound:      "peer_found",
+	LookupDone:     "lookup_done",
+}
+
+// jsonEvent is the JSON form of a LookupEvent.
+type jsonEvent struct {
+	Event    string `json:"event"`
+	ID       string `json:"id,omitempty"`
+	Addr     string `json:"addr,omitempty"`
+	Peer     string `json:"peer,omitempty"`
+	Hops     int    `json:"hops,omitempty"`
+	Queries  int    `json:"queries,omitempty"`
+	Timeouts int    `json:"timeouts,omitempty"`
+	Peers    int    `json:"peers,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newJSONEvent(e LookupEvent) *jsonEvent {
+	j := &jsonEvent{Event: eventNames[e.Kind]}
+	if e.Contact != nil {
+		j.ID = e.Contact.id.HexString()
+		j.Addr = addrKey(e.Contact)
+	}
+	if e.Peer != nil {
+		j.Peer = e.Peer.String()
+	}
+	if e.Result != nil {
+		j.Hops, j.Queries, j.Timeouts, j.Peers = e.Result.Hops, e.Result.Queries, e.Result.Timeouts, len(e.Result.Peers)
+	}
+	if e.Err != nil {
+		j.Error = e.Err.Error()
+	}
+	return j
+}
+
+// LookupHandler runs a lookup on node and streams its events as newline
+// delimited JSON, e.g. GET /lookup?target=<hex>&method=get_peers.
+func LookupHandler(node *Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := HexToID(r.URL.Query().Get("target"))
+		if target == nil {
+			http.Error(w, "target must be 40 hex characters", http.StatusBadRequest)
+			return
+		}
+
+		var events <-chan LookupEvent
+		switch method := r.URL.Query().Get("method"); method {
+		case "", "find_node":
+			events = node.FindNodeEvents(r.Context(), target)
+		case "get_peers":
+			events = node.GetPeersEvents(r.Context(), target)
+		default:
+			http.Error(w, fmt.Sprintf("unknown method %q", method), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		for e := range events {
+			if err := enc.Encode(newJSONEvent(e)); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// TracesHandler serves recent lookup traces, most recent first,
+// e.g. GET /debug/lookups?n=10.
+func TracesHandler(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+	writeJSON(w, traces.recent(n))
+}
+
+// TraceStatsHandler serves aggregate stats of recent lookups.
+func TraceStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, traces.stats())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// IdentitiesHandler serves the yield of the identities of c.
+func IdentitiesHandler(c *Crawler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.Stats())
+	}
+}
+
+// RouterHandler serves the number of contacts and pings of r.
+func RouterHandler(r *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Stats())
+	}
+}
+
+// StatusHandler serves the status of the running nodes,
+// see CurrentStatus.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, CurrentStatus())
+}
@@ -1,4 +1,4 @@
-package main
+package dht
 
 import (
 	"bytes"
@@ -24,8 +24,8 @@ func (id Identifier) toInt() *big.Int {
 	return big.NewInt(0).SetBytes(id)
 }
 
-// hexString returns the id in hex format.
-func (id Identifier) hexString() string {
+// HexString returns the id in hex format.
+func (id Identifier) HexString() string {
 	return hex.EncodeToString(id)
 }
 
@@ -39,17 +39,31 @@ func distance(x, y Identifier) []byte {
 	return dist
 }
 
-// randID generates a random identifier.
-func randID() Identifier {
+// prefixLen returns the number of leading zero bits of a distance.
+func prefixLen(dist []byte) int {
+	for i, b := range dist {
+		if b != 0 {
+			j := 0
+			for ; b&0x80 == 0; b <<= 1 {
+				j++
+			}
+			return 8*i + j
+		}
+	}
+	return 8 * len(dist)
+}
+
+// RandomID generates a random identifier.
+func RandomID() Identifier {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	h := sha1.New()
 	io.WriteString(h, time.Now().String()) // each call returns a different identifier
-	io.WriteString(h, string(r.Int()))
+	io.WriteString(h, strconv.Itoa(r.Int()))
 	return h.Sum(nil)
 }
 
-// hexToID turns a 40 character string to a 20 byte identifier.
-func hexToID(s string) Identifier {
+// HexToID turns a 40 character string to a 20 byte identifier.
+func HexToID(s string) Identifier {
 	if len(s) != 40 {
 		return nil
 	}
@@ -1,4 +1,4 @@
-package main
+package dht
 
 import (
 	"bytes"
@@ -7,14 +7,14 @@ import (
 )
 
 func TestGenerateID(t *testing.T) {
-	id := randID()
+	id := RandomID()
 	fmt.Println(id.String())
 }
 
 func TestHexToID(t *testing.T) {
 	s1 := "1111111111111111111111111111111111111110"
-	id := hexToID(s1)
-	s2 := id.hexString()
+	id := HexToID(s1)
+	s2 := id.HexString()
 
 	if s1 != s2 {
 		t.Errorf("expected: %s, got: %s", s1, s2)
@@ -22,9 +22,9 @@ func TestHexToID(t *testing.T) {
 }
 
 func TestIDToHex(t *testing.T) {
-	id1 := randID()
-	s := id1.hexString()
-	id2 := hexToID(s)
+	id1 := RandomID()
+	s := id1.HexString()
+	id2 := HexToID(s)
 
 	if !bytes.Equal(id1, id2) {
 		t.Errorf("expected: %s, got: %s", id1, id2)
@@ -32,8 +32,8 @@ func TestIDToHex(t *testing.T) {
 }
 
 func TestCalcDistance(t *testing.T) {
-	id1 := randID()
-	id2 := randID()
+	id1 := RandomID()
+	id2 := RandomID()
 
 	dist12 := distance(id1, id2)
 	dist21 := distance(id2, id1)
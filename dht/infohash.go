@@ -0,0 +1,75 @@
+package dht
+
+import (
+	"expvar"
+	"net"
+	"time"
+)
+
+// InfohashSeen reports an infohash another node sent us a get_peers or
+// announce_peer query for.
+type InfohashSeen struct {
+	Infohash Identifier
+	Source   *Contact
+	Method   string // get_peers or announce_peer
+	Time     time.Time
+
+	// Port is the announced port of an announce_peer query.
+	Port int
+}
+
+// infohashStats counts infohashes seen by all nodes in this process,
+// duplicates are the ones dropped by the dedupe filter and dropped are
+// the ones a full storage queue had no room for.
+var infohashStats = expvar.NewMap("dht_infohashes")
+
+// OnInfohash registers fn to be called with every InfohashSeen event of
+// node. Repeats of a method and infohash within InfohashDedupeWindow are
+// dropped by a bloom filter, rarely a new one may be dropped too.
+// fn is called from the storage queue of node.
+func (node *Node) OnInfohash(fn func(InfohashSeen)) {
+	node.subscribers.Lock()
+	defer node.subscribers.Unlock()
+	node.subscribers.fns = append(node.subscribers.fns, fn)
+}
+
+// infohashSeen passes e through dedupe filter to subscribers. The filter
+// records e only once it is queued, so a dropped event isn't taken for a
+// duplicate when the infohash is seen again.
+func (node *Node) infohashSeen(e InfohashSeen) {
+	infohashStats.Add("seen", 1)
+	key := append([]byte(e.Method+":"), e.Infohash...)
+	if node.infohashes.contains(key) {
+		infohashStats.Add("duplicates", 1)
+		return
+	}
+
+	node.subscribers.RLock()
+	fns := node.subscribers.fns
+	node.subscribers.RUnlock()
+	if len(fns) == 0 {
+		return
+	}
+	queued := node.storage.push(func() {
+		for _, fn := range fns {
+			fn(e)
+		}
+	}, nil)
+	if !queued {
+		infohashStats.Add("dropped", 1)
+		return
+	}
+	node.infohashes.seen(key)
+}
+
+// newInfohashSeen returns the event of a query from addr with args.
+func newInfohashSeen(method string, infohash Identifier, addr *net.UDPAddr, args map[string]interface{}, port int) InfohashSeen {
+	id, _ := args["id"].(string)
+	return InfohashSeen{
+		Infohash: infohash,
+		Source:   &Contact{id: Identifier(id), ip: addr.IP, port: addr.Port, lastSeen: time.Now()},
+		Method:   method,
+		Time:     time.Now(),
+		Port:     port,
+	}
+}
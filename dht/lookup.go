@@ -0,0 +1,425 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// States of a lookup candidate.
+const (
+	candidatePending = iota
+	candidateQueried
+	candidateResponded
+	candidateFailed
+	candidateReplaced // answered with another id, see lookup.verify
+)
+
+// LookupResult describes the outcome of an iterative lookup.
+type LookupResult struct {
+	Target Identifier
+
+	// Closest holds at most k closest nodes which answered,
+	// closest first.
+	Closest []*Contact
+
+	// Hops is the number of hops from the start nodes to the
+	// farthest of the closest nodes.
+	Hops     int
+	Queries  int
+	Timeouts int
+	Duration time.Duration
+
+	// Peers holds peer addresses found by a get_peers lookup,
+	// Tokens holds announce tokens of the closest nodes keyed by hex id.
+	Peers  []*net.UDPAddr
+	Tokens map[string]string
+}
+
+// candidate is a node which has been learnt during a lookup, rtt is the
+// round trip time of contact when it was learnt, candidates are ordered
+// by it.
+type candidate struct {
+	contact *Contact
+	rtt     time.Duration
+	path    *path
+	hop     int
+	state   int
+	token   string
+}
+
+// reply is the outcome of a single request of a lookup,
+// req is nil if the request timed out.
+type reply struct {
+	cand *candidate
+	req  *Request
+	rtt  time.Duration
+}
+
+// path is one of the disjoint paths of a lookup. shortlist holds the
+// path's candidates that haven't failed, sorted by distance to target.
+type path struct {
+	shortlist []*candidate
+	inflight  int
+}
+
+// lookup is an iterative Kademlia lookup towards target. Each path keeps
+// alpha requests in flight and sends a new one as soon as any of them
+// finishes, a slow node therefore never stalls the whole lookup.
+//
+// With more than one path the lookup runs S/Kademlia style: start nodes are
+// spread over the paths and a node belongs to the path which learnt about it
+// first, so no node is queried by more than one path and a malicious node
+// can only mislead its own path. Results of all paths are merged.
+type lookup struct {
+	node   *Node
+	target Identifier
+	method string // find_node or get_peers
+	alpha  int
+	k      int
+
+	// peers holds peers found so far. listeners are called
+	// with every event of the lookup.
+	peers     map[string]bool
+	listeners []func(LookupEvent)
+
+	// trace records every query of the lookup.
+	trace *LookupTrace
+
+	// seen holds every candidate ever added to any path, keyed by hex id.
+	paths   []*path
+	seen    map[string]*candidate
+	replies chan *reply
+
+	result *LookupResult
+}
+
+func newLookup(node *Node, target Identifier, method string) *lookup {
+	paths := node.paths
+	if paths < 1 {
+		paths = 1
+	}
+	return &lookup{
+		node:    node,
+		target:  target,
+		method:  method,
+		alpha:   config.LookupAlpha,
+		k:       maxNodesPerBucket,
+		paths:   make([]*path, paths),
+		peers:   make(map[string]bool),
+		seen:    make(map[string]*candidate),
+		replies: make(chan *reply),
+		result:  &LookupResult{Target: target, Tokens: make(map[string]string)},
+		trace:   &LookupTrace{Target: target.HexString(), Method: method, Start: time.Now()},
+	}
+}
+
+// run starts the lookup from contacts and blocks until the k closest
+// candidates of every path have all answered, no candidate is left,
+// or ctx is done.
+func (l *lookup) run(ctx context.Context, contacts []*Contact) (*LookupResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	contacts = append([]*Contact(nil), contacts...)
+	rtts := make(map[*Contact]time.Duration, len(contacts))
+	for _, c := range contacts {
+		rtts[c] = c.estimatedRTT()
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		a, b := contacts[i], contacts[j]
+		return closerRTT(l.target, a, b, rtts[a], rtts[b])
+	})
+	if len(contacts) < len(l.paths) {
+		l.paths = l.paths[:len(contacts)]
+	}
+	for i := range l.paths {
+		l.paths[i] = new(path)
+	}
+	for i, c := range contacts {
+		l.add(l.paths[i%len(l.paths)], []*Contact{c}, 0)
+	}
+
+	var err error
+loop:
+	for l.refill(ctx) {
+		select {
+		case r := <-l.replies:
+			r.cand.path.inflight--
+			l.handle(r)
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+
+	var responded []*candidate
+	for _, c := range l.seen {
+		if c.state == candidateResponded {
+			responded = append(responded, c)
+		}
+	}
+	sort.Slice(responded, func(i, j int) bool { return l.closer(responded[i], responded[j]) })
+	for _, c := range responded {
+		if len(l.result.Closest) == l.k {
+			break
+		}
+		l.result.Closest = append(l.result.Closest, c.contact)
+		if c.token != "" {
+			l.result.Tokens[c.contact.id.HexString()] = c.token
+		}
+		if c.hop+1 > l.result.Hops {
+			l.result.Hops = c.hop + 1
+		}
+	}
+	l.result.Duration = time.Since(start)
+	return l.result, err
+}
+
+// add inserts contacts learnt at given hop into shortlist of p, contacts
+// which already belong to a path are skipped.
+func (l *lookup) add(p *path, contacts []*Contact, hop int) {
+	for _, c := range contacts {
+		if len(c.id) != 20 || bytes.Equal(c.id, l.node.info.id) {
+			continue
+		}
+		if _, ok := l.seen[c.id.HexString()]; ok {
+			continue
+		}
+		cand := &candidate{contact: c, rtt: c.estimatedRTT(), path: p, hop: hop}
+		l.seen[c.id.HexString()] = cand
+		l.insert(cand)
+		l.emit(LookupEvent{Kind: NodeDiscovered, Contact: c})
+	}
+}
+
+// on registers fn to be called with every event of the lookup.
+func (l *lookup) on(fn func(LookupEvent)) {
+	l.listeners = append(l.listeners, fn)
+}
+
+func (l *lookup) emit(e LookupEvent) {
+	for _, fn := range l.listeners {
+		fn(e)
+	}
+}
+
+// insert puts cand into shortlist of its path keeping it sorted.
+func (l *lookup) insert(cand *candidate) {
+	p := cand.path
+	i := sort.Search(len(p.shortlist), func(i int) bool { return l.closer(cand, p.shortlist[i]) })
+	p.shortlist = append(p.shortlist, nil)
+	copy(p.shortlist[i+1:], p.shortlist[i:])
+	p.shortlist[i] = cand
+}
+
+// closer orders candidates like closer orders contacts, by the round
+// trip times they were learnt with.
+func (l *lookup) closer(a, b *candidate) bool {
+	return closerRTT(l.target, a.contact, b.contact, a.rtt, b.rtt)
+}
+
+// remove takes cand out of shortlist of its path.
+func (l *lookup) remove(cand *candidate) {
+	p := cand.path
+	for i, c := range p.shortlist {
+		if c == cand {
+			p.shortlist = append(p.shortlist[:i], p.shortlist[i+1:]...)
+			return
+		}
+	}
+}
+
+// refill sends requests to the closest pending candidates of every path
+// until alpha requests of the path are in flight. It returns false once
+// the lookup is finished, that is when the k closest candidates of every
+// path have all answered.
+func (l *lookup) refill(ctx context.Context) bool {
+	running := false
+	for _, p := range l.paths {
+		running = l.refillPath(ctx, p) || running
+	}
+	return running
+}
+
+func (l *lookup) refillPath(ctx context.Context, p *path) bool {
+	for {
+		waiting, failed := false, false
+		for i, c := range p.shortlist {
+			if i == l.k {
+				break
+			}
+			if c.state == candidatePending && p.inflight < l.alpha && !l.send(ctx, c) {
+				failed = true
+				continue
+			}
+			if c.state == candidatePending || c.state == candidateQueried {
+				waiting = true
+			}
+		}
+		if !failed {
+			return waiting && p.inflight > 0
+		}
+		p.prune()
+	}
+}
+
+// prune removes failed candidates from shortlist.
+func (p *path) prune() {
+	shortlist := p.shortlist[:0]
+	for _, c := range p.shortlist {
+		if c.state != candidateFailed {
+			shortlist = append(shortlist, c)
+		}
+	}
+	p.shortlist = shortlist
+}
+
+// send queries cand and waits for its answer in background,
+// it returns false if the request couldn't be sent.
+func (l *lookup) send(ctx context.Context, cand *candidate) bool {
+	c := cand.contact
+	var txid uint32
+	var data string
+	var err error
+	if l.method == "get_peers" {
+		txid, data, err = l.node.krpc.encodeGetPeers(l.node.info.id.String(), l.target)
+	} else {
+		txid, data, err = l.node.krpc.encodeFindNode(l.node.info.id.String(), l.target)
+	}
+	if err != nil {
+		log.Printf("error occurred while constructing %s request to %s", l.method, c)
+		cand.state = candidateFailed
+		return false
+	}
+
+	r := NewRequest(c, txid)
+	if !l.node.track(r) {
+		cand.state = candidateFailed
+		return false
+	}
+	addr := &net.UDPAddr{IP: c.ip, Port: c.port}
+	if _, err = l.node.transport.writeMsgUDP([]byte(data), addr); err != nil {
+		cand.state = candidateFailed
+		return false
+	}
+
+	cand.state = candidateQueried
+	cand.path.inflight++
+	l.result.Queries++
+	go func() {
+		req := waitResponse(ctx, r)
+		select {
+		case l.replies <- &reply{cand: cand, req: req, rtt: time.Since(r.sent)}:
+		case <-ctx.Done():
+		}
+	}()
+	return true
+}
+
+// handle processes the answer of a single request.
+func (l *lookup) handle(r *reply) {
+	c := r.cand.contact
+	q := &TraceQuery{
+		ID:       c.id.HexString(),
+		Addr:     addrKey(c),
+		Distance: 160 - prefixLen(distance(c.id, l.target)),
+		TimedOut: r.req == nil,
+	}
+	if r.req != nil {
+		q.RTT = r.rtt
+	}
+	l.trace.record(r.cand.hop, q)
+
+	if r.req == nil {
+		r.cand.state = candidateFailed
+		l.remove(r.cand)
+		l.result.Timeouts++
+		return
+	}
+	resp, ok := r.req.resp.ext.(*Response)
+	if !ok {
+		r.cand.state = candidateFailed
+		l.remove(r.cand)
+		return
+	}
+
+	r.cand.state = candidateResponded
+	cand := l.verify(r.cand, resp)
+	l.emit(LookupEvent{Kind: NodeResponded, Contact: cand.contact})
+	if nodes, ok := resp.r["nodes"].(string); ok {
+		l.add(cand.path, l.node.known(decodeContacts([]byte(nodes))), cand.hop+1)
+	}
+	if token, ok := resp.r["token"].(string); ok {
+		cand.token = token
+	}
+	if values, ok := resp.r["values"].([]interface{}); ok {
+		for _, v := range values {
+			if peer, ok := v.(string); ok && (len(peer) == 6 || len(peer) == 18) && !l.peers[peer] {
+				l.peers[peer] = true
+				addr := peerAddr(peer)
+				l.result.Peers = append(l.result.Peers, addr)
+				l.emit(LookupEvent{Kind: PeerFound, Contact: cand.contact, Peer: addr})
+			}
+		}
+	}
+}
+
+// verify checks the id a candidate answered with. Bootstrap nodes are
+// added with made up ids, they're replaced by a candidate with the real
+// id so that distances and results are right.
+func (l *lookup) verify(cand *candidate, resp *Response) *candidate {
+	id, ok := resp.r["id"].(string)
+	if !ok || len(id) != 20 || id == cand.contact.id.String() {
+		return cand
+	}
+
+	l.remove(cand)
+	cand.state = candidateReplaced
+	c := &Contact{
+		id:       Identifier(id),
+		ip:       cand.contact.ip,
+		port:     cand.contact.port,
+		status:   Good,
+		lastSeen: time.Now(),
+	}
+	c.inherit(cand.contact)
+	if _, ok := l.seen[c.id.HexString()]; ok {
+		return cand
+	}
+	verified := &candidate{contact: c, rtt: c.estimatedRTT(), path: cand.path, hop: cand.hop, state: candidateResponded}
+	l.seen[c.id.HexString()] = verified
+	l.insert(verified)
+	return verified
+}
+
+// finish completes trace of the lookup and keeps it in traces.
+func (l *lookup) finish(result *LookupResult, err error) {
+	t := l.trace
+	t.Duration = time.Since(t.Start)
+	if result != nil {
+		t.ResultHops = result.Hops
+		t.Queries = result.Queries
+		t.Timeouts = result.Timeouts
+		t.Peers = len(result.Peers)
+		t.Success = err == nil && len(result.Closest) > 0
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	traces.add(t)
+}
+
+// answered checks if contact c answered during lookup.
+func (l *lookup) answered(c *Contact) (queried, answered bool) {
+	cand, ok := l.seen[c.id.HexString()]
+	if !ok {
+		return false, false
+	}
+	return cand.state != candidatePending,
+		cand.state == candidateResponded || cand.state == candidateReplaced
+}
@@ -0,0 +1,188 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+// startTestNodes starts n nodes on localhost which know each other,
+// they are stopped when the test finishes.
+func startTestNodes(t *testing.T, n int) []*Node {
+	var nodes []*Node
+	for i := 0; i < n; i++ {
+		node := newTestNode(t)
+		node.info.ip = net.IPv4(127, 0, 0, 1)
+		node.info.port = node.Addr().Port
+		node.table.subnetBucketLimit = 0
+		node.table.subnetTableLimit = 0
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		for _, other := range nodes {
+			if node != other {
+				c := NewContact(other.info.id)
+				c.ip, c.port = other.info.ip, other.info.port
+				node.table.insertNode(c)
+			}
+		}
+		node.Start(context.Background())
+		t.Cleanup(node.Stop)
+	}
+	return nodes
+}
+
+func TestLookupFindsClosest(t *testing.T) {
+	nodes := startTestNodes(t, 30)
+	target := RandomID()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := nodes[0].FindNode(ctx, target)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var others []*Contact
+	for _, n := range nodes[1:] {
+		others = append(others, n.info)
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return bytes.Compare(distance(others[i].id, target), distance(others[j].id, target)) < 0
+	})
+
+	if len(result.Closest) != maxNodesPerBucket {
+		t.Fatalf("expected %d closest nodes, got: %d nodes", maxNodesPerBucket, len(result.Closest))
+	}
+	found := false
+	for _, c := range result.Closest {
+		found = found || bytes.Equal(c.id, others[0].id)
+	}
+	if !found {
+		t.Errorf("expected %s among closest nodes, got: %v", others[0], result.Closest)
+	}
+	if result.Queries == 0 || result.Hops == 0 {
+		t.Errorf("expected queries and hops to be counted, got: %d queries, %d hops",
+			result.Queries, result.Hops)
+	}
+}
+
+func TestLookupEvents(t *testing.T) {
+	nodes := startTestNodes(t, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kinds := make(map[int]int)
+	var last LookupEvent
+	for e := range nodes[0].FindNodeEvents(ctx, RandomID()) {
+		kinds[e.Kind]++
+		last = e
+	}
+
+	if last.Kind != LookupDone || last.Result == nil || last.Err != nil {
+		t.Fatalf("expected lookup done as last event, got: %+v", last)
+	}
+	if kinds[NodeDiscovered] == 0 || kinds[NodeResponded] != last.Result.Queries-last.Result.Timeouts {
+		t.Errorf("expected discovered and responded events, got: %v", kinds)
+	}
+}
+
+func TestLookupPeers(t *testing.T) {
+	nodes := startTestNodes(t, 10)
+	ih := RandomID()
+	peer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	for _, n := range nodes[1:] {
+		n.peers.Add(ih, peer)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	found := 0
+	var last LookupEvent
+	for e := range nodes[0].GetPeersEvents(ctx, ih) {
+		if e.Kind == PeerFound {
+			found++
+			if e.Peer.String() != peer.String() {
+				t.Errorf("expected peer %s, got: %v", peer, e.Peer)
+			}
+		}
+		last = e
+	}
+	if found != 1 || last.Result == nil || len(last.Result.Peers) != 1 || last.Result.Peers[0].String() != peer.String() {
+		t.Errorf("expected peer %s found once, got: %d events, result %+v", peer, found, last.Result)
+	}
+}
+
+func TestLookupRTTSnapshot(t *testing.T) {
+	node := newTestNode(t)
+	defer node.Stop()
+	target := make(Identifier, 20)
+	l := newLookup(node, target, "find_node")
+	l.paths[0] = new(path)
+
+	// a, b and c fall into one bucket of target
+	contact := func(b byte, rtt time.Duration) *Contact {
+		id := make(Identifier, 20)
+		id[0], id[1] = 0x80, b
+		c := NewContact(id)
+		c.observeRTT(rtt)
+		return c
+	}
+	a, b := contact(1, time.Millisecond), contact(2, 3*time.Millisecond)
+	l.add(l.paths[0], []*Contact{a, b}, 0)
+
+	// measurements taken during the lookup reverse a and b
+	a.mu.Lock()
+	a.rtt = 5 * time.Millisecond
+	a.mu.Unlock()
+	b.mu.Lock()
+	b.rtt = time.Microsecond
+	b.mu.Unlock()
+
+	c := contact(3, 2*time.Millisecond)
+	l.add(l.paths[0], []*Contact{c}, 0)
+	var got []*Contact
+	for _, cand := range l.paths[0].shortlist {
+		got = append(got, cand.contact)
+	}
+	if len(got) != 3 || got[0] != a || got[1] != c || got[2] != b {
+		t.Errorf("expected shortlist ordered by round trip times when learnt, got: %v", got)
+	}
+}
+
+func TestLookupDisjointPaths(t *testing.T) {
+	nodes := startTestNodes(t, 30)
+	target := RandomID()
+
+	nodes[0].paths = 3
+	l := newLookup(nodes[0], target, "find_node")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := l.run(ctx, nodes[0].table.findLocalClosest(target))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if len(l.paths) != 3 {
+		t.Fatalf("expected 3 paths, got: %d paths", len(l.paths))
+	}
+	responded := make(map[*path]int)
+	for _, c := range l.seen {
+		if c.state == candidateResponded {
+			responded[c.path]++
+		}
+	}
+	for i, p := range l.paths {
+		if responded[p] == 0 {
+			t.Errorf("expected nodes to answer on path %d", i)
+		}
+	}
+	if len(result.Closest) != maxNodesPerBucket {
+		t.Errorf("expected %d closest nodes, got: %d nodes", maxNodesPerBucket, len(result.Closest))
+	}
+}
@@ -0,0 +1,79 @@
+package dht
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// InboundMiddleware is called with every decoded message a node receives
+// before it is handled. It may inspect or rewrite m and returns the message
+// to pass on, nil drops the message.
+type InboundMiddleware func(m *KRPCMessage) *KRPCMessage
+
+// OutboundMiddleware is called with every encoded message a node sends to
+// addr. It returns the data to send, nil drops the message.
+type OutboundMiddleware func(data []byte, addr *net.UDPAddr) []byte
+
+// middleware holds ordered inbound and outbound middleware of a node.
+type middleware struct {
+	mu       sync.RWMutex
+	inbound  []InboundMiddleware
+	outbound []OutboundMiddleware
+}
+
+// in runs m through inbound middleware in order they were added.
+func (mw *middleware) in(m *KRPCMessage) *KRPCMessage {
+	mw.mu.RLock()
+	defer mw.mu.RUnlock()
+	for _, fn := range mw.inbound {
+		if m = fn(m); m == nil {
+			return nil
+		}
+	}
+	return m
+}
+
+// out runs data through outbound middleware in order they were added.
+func (mw *middleware) out(data []byte, addr *net.UDPAddr) []byte {
+	mw.mu.RLock()
+	defer mw.mu.RUnlock()
+	for _, fn := range mw.outbound {
+		if data = fn(data, addr); data == nil {
+			return nil
+		}
+	}
+	return data
+}
+
+// UseInbound appends fns to inbound middleware of node.
+func (node *Node) UseInbound(fns ...InboundMiddleware) {
+	mw := node.transport.middleware
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.inbound = append(mw.inbound, fns...)
+}
+
+// UseOutbound appends fns to outbound middleware of node.
+func (node *Node) UseOutbound(fns ...OutboundMiddleware) {
+	mw := node.transport.middleware
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.outbound = append(mw.outbound, fns...)
+}
+
+// LogInbound is an InboundMiddleware which logs every received message.
+func LogInbound(m *KRPCMessage) *KRPCMessage {
+	if m.y == "q" {
+		log.Printf("<========= received %s from %s", m.Method(), m.addr)
+	} else {
+		log.Printf("<========= received %q message from %s", m.y, m.addr)
+	}
+	return m
+}
+
+// LogOutbound is an OutboundMiddleware which logs every sent message.
+func LogOutbound(data []byte, addr *net.UDPAddr) []byte {
+	log.Printf("=========> sent out %d bytes to addr %v", len(data), addr)
+	return data
+}
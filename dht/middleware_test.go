@@ -0,0 +1,41 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	nodes := startTestNodes(t, 2)
+
+	var pings int32
+	nodes[1].UseInbound(func(m *KRPCMessage) *KRPCMessage {
+		if m.Method() == "ping" {
+			atomic.AddInt32(&pings, 1)
+		}
+		return m
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := nodes[0].Ping(ctx, nodes[1].Addr()); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&pings); n != 1 {
+		t.Errorf("expected 1 ping counted, got: %d", n)
+	}
+
+	nodes[1].UseOutbound(func(data []byte, addr *net.UDPAddr) []byte {
+		return nil
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := nodes[0].Ping(ctx, nodes[1].Addr()); err == nil {
+		t.Error("expected ping to fail when replies are dropped")
+	}
+	if n := atomic.LoadInt32(&pings); n != 2 {
+		t.Errorf("expected 2 pings counted, got: %d", n)
+	}
+}
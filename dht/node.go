@@ -0,0 +1,468 @@
+package dht
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+	// log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// UDPPacketSize is the default UDP read buffer size
+	UDPPacketSize = 1024
+)
+
+// Bootstrappers are well known torrent nodes,
+// a node need to contact at least one nodes to join the
+// torrent network.
+var Bootstrappers = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"service.ygrek.org.ua:6881",
+	"router.utorrent.com:6881",
+	"router.transmission.com:6881",
+}
+
+// A Node represents a DHT node in the torrent network.
+type Node struct {
+	// Info specifies contact information of current node.
+	info *Contact
+
+	// Table represents local routing table of current node.
+	table *RoutingTable
+
+	// reads, messages, dispatch and storage are the queues between
+	// the stages of the node, see queue.go. dispatched is closed once
+	// all queries have been dispatched after the node stopped.
+	krpc       *KRPC
+	reads      *queue
+	messages   *queue
+	dispatch   *queue
+	storage    *queue
+	dispatched chan struct{}
+
+	// transport is a UDP transport which is used for communication in the DHT network.
+	// reqMap is a hashtable which records requests history, so that all
+	// requests for one transaction can be correlated.
+	transport *UDPTransport
+	reqC      chan *Request
+	reqMap    map[string]*Request
+
+	// tokens generates and validates announce tokens,
+	// its secret changes every 5 min.
+	tokens *tokenSecrets
+
+	// peers holds peers announced to this node and peers
+	// found by its get_peers lookups.
+	peers PeerStore
+
+	// store saves snapshots of the node, it may be nil.
+	store NodeStore
+
+	// restored holds contacts loaded from a snapshot, they are
+	// pinged before going into routing table.
+	restored []*Contact
+
+	// boot provides bootstrap nodes when routing table is empty.
+	boot *bootstrapper
+
+	// announcer holds the infohashes this node announces.
+	announcer *announcer
+
+	// handlers answer queries by method name.
+	handlers *handlers
+
+	// paths is the number of disjoint paths of each lookup.
+	paths int
+
+	// peerResults caches results of get_peers lookups.
+	peerResults *peerCache
+
+	// sizes estimates the number of nodes in the network from
+	// lookups for random targets.
+	sizes *sizeEstimator
+
+	// infohashes drops repeated InfohashSeen events before they
+	// reach subscribers.
+	infohashes  *rotatingBloom
+	subscribers struct {
+		sync.RWMutex
+		fns []func(InfohashSeen)
+	}
+
+	onPeer func(infohash Identifier, peer *net.UDPAddr)
+
+	// ctx is cancelled when the node stops, wg tracks
+	// goroutines started by Start.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewNode returns a new DHT node configured by opts.
+func NewNode(opts ...Option) (*Node, error) {
+	o := &options{
+		bootstrap:    config.Bootstrap,
+		paths:        config.LookupPaths,
+		subnetBucket: config.SubnetBucketLimit,
+		subnetTable:  config.SubnetTableLimit,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.id == nil {
+		o.id = RandomID()
+	}
+	if o.peers == nil {
+		o.peers = NewMemoryPeerStore(config.MaxPeersPerInfohash, config.PeerTTL)
+	}
+	transport, err := NewTransport(o.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	node := &Node{
+		info:        NewContact(o.id),
+		table:       NewRoutingTable(o.id),
+		krpc:        new(KRPC),
+		transport:   transport,
+		reqC:        make(chan *Request),
+		reads:       newQueue("read", config.ReadQueueSize, config.ReadQueuePolicy),
+		messages:    newQueue("message", config.MessageQueueSize, Block),
+		dispatch:    newQueue("dispatch", config.DispatchQueueSize, config.DispatchQueuePolicy),
+		storage:     newQueue("storage", config.StorageQueueSize, config.StorageQueuePolicy),
+		dispatched:  make(chan struct{}),
+		reqMap:      make(map[string]*Request),
+		tokens:      newTokenSecrets(),
+		peers:       o.peers,
+		store:       o.store,
+		boot:        newBootstrapper(o.bootstrap, o.store),
+		announcer:   newAnnouncer(),
+		handlers:    newHandlers(),
+		paths:       o.paths,
+		peerResults: newPeerCache(ctx, config.PeerCacheTTL, config.PeerCacheSize),
+		sizes:       newSizeEstimator(config.SizeSamples),
+		infohashes:  newRotatingBloom(config.InfohashDedupeSize, config.InfohashDedupeRate, config.InfohashDedupeWindow),
+		onPeer:      o.onPeer,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	node.table.subnetBucketLimit, node.table.subnetTableLimit = o.subnetBucket, o.subnetTable
+	for method, handler := range o.handlers {
+		node.Handle(method, handler)
+	}
+	for _, fn := range o.onInfohash {
+		node.OnInfohash(fn)
+	}
+	node.UseInbound(o.inbound...)
+	node.UseOutbound(o.outbound...)
+	if node.store != nil {
+		node.restore()
+	}
+	return node, nil
+}
+
+// ID returns id of the node.
+func (node *Node) ID() Identifier {
+	return node.info.id
+}
+
+// Addr returns the local UDP address of the node.
+func (node *Node) Addr() *net.UDPAddr {
+	return node.transport.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Contacts returns all contacts in routing table of the node.
+func (node *Node) Contacts() []*Contact {
+	return node.table.contacts()
+}
+
+// Start brings a node up and initiates all listeners, the node stops
+// when ctx is done or Stop is called.
+func (node *Node) Start(ctx context.Context) {
+	log.Printf("starting node %s", node.info)
+	running.Lock()
+	running.nodes[node] = true
+	running.Unlock()
+
+	node.goroutine(node.startUDPListener)
+	node.goroutine(node.startDecoder)
+	node.goroutine(node.startMsgBroker)
+	node.goroutine(node.startDispatcher)
+	node.goroutine(node.startStorage)
+	node.goroutine(node.startUpdater)
+	node.goroutine(node.startAnnouncer)
+	node.goroutine(node.maintain)
+	node.goroutine(node.estimateSize)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			node.Stop()
+		case <-node.ctx.Done():
+		}
+	}()
+}
+
+// Stop closes the socket, waits for all goroutines of the node to
+// return and saves a last snapshot of the node.
+func (node *Node) Stop() {
+	node.stopOnce.Do(func() {
+		log.Printf("stopping node %s", node.info)
+		node.cancel()
+		node.transport.conn.Close()
+		node.wg.Wait()
+		node.persist()
+
+		running.Lock()
+		delete(running.nodes, node)
+		running.Unlock()
+	})
+}
+
+// goroutine runs fn in a goroutine which Stop waits for.
+func (node *Node) goroutine(fn func()) {
+	node.wg.Add(1)
+	go func() {
+		defer node.wg.Done()
+		fn()
+	}()
+}
+
+// maintain rotates token secrets, expires peers and
+// saves a snapshot of the node periodically.
+func (node *Node) maintain() {
+	ticker := time.NewTicker(config.TokenRotation)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			node.tokens.rotate()
+			if _, err := node.peers.Expire(); err != nil {
+				log.Printf("error occurred while expiring peers: %v", err)
+			}
+			node.persist()
+		case <-node.ctx.Done():
+			return
+		}
+	}
+}
+
+// startUDPListener starts a listener for incoming UDP messages, packets
+// are put into the read queue for the decoder. It closes the read queue
+// once the socket is closed.
+func (node *Node) startUDPListener() {
+	log.Printf("starting UDP listener...")
+	defer close(node.reads.c)
+	buffer := make([]byte, UDPPacketSize)
+	for {
+		node.transport.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		n, addr, err := node.transport.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if node.ctx.Err() != nil {
+				return
+			}
+			log.Printf("error occurred while reading from UDP: [%v]", err)
+			continue
+		}
+		node.reads.push(&packet{data: string(buffer[:n]), addr: addr}, nil)
+	}
+}
+
+// packet is a UDP packet waiting to be decoded.
+type packet struct {
+	data string
+	addr *net.UDPAddr
+}
+
+// startDecoder decodes packets of the read queue, after a message has
+// passed inbound middleware it is sent over to message broker. It closes
+// the message queue once the read queue is closed and drained.
+func (node *Node) startDecoder() {
+	defer close(node.messages.c)
+	for item := range node.reads.c {
+		p := item.(*packet)
+		message, err := node.krpc.decode(p.data, p.addr)
+		if err != nil || message == nil {
+			log.Printf("error occurred while decoding data from %s", p.addr)
+		} else if message = node.transport.middleware.in(message); message != nil {
+			// throw to the message broker
+			node.messages.push(message, nil)
+		}
+	}
+}
+
+// startMsgBroker starts a message broker that listens for two things:
+// 1) in case of an outgoing request, save {request_id: request} in a map.
+// 2) in case of a krpc message, it checks if it's a response related to
+// an existing request, otherwise it puts the query into the dispatch queue.
+// It returns when the message queue is closed, so that messages which
+// were already read are still handled when the node stops.
+func (node *Node) startMsgBroker() {
+	log.Printf("starting message broker...")
+	defer close(node.dispatch.c)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case req := <-node.reqC:
+			// log.Printf("msg broker receiving from reqC channel")
+			node.reqMap[req.txid] = req
+		case item, ok := <-node.messages.c:
+			// log.Printf("msg broker receiving from msgC channel")
+			if !ok {
+				return
+			}
+			msg := item.(*KRPCMessage)
+
+			// queries of other nodes may reuse transaction ids of our
+			// requests, only responses and errors answer them
+			if req, ok := node.reqMap[msg.t]; ok && msg.y != "q" {
+				// log.Printf("we already have this req with this transaction id")
+
+				req.resp = msg
+				req.respC <- req
+				delete(node.reqMap, msg.t)
+			} else if msg.y == "q" {
+				node.dispatch.push(msg, nil)
+			}
+
+		case <-ticker.C:
+			// forget requests nobody is waiting for anymore
+			for txid, req := range node.reqMap {
+				if time.Since(req.sent) > config.MaxTimeout {
+					delete(node.reqMap, txid)
+				}
+			}
+		}
+	}
+}
+
+// startDispatcher answers queries of the dispatch queue with a pool of
+// DispatchWorkers workers, it closes dispatched once the dispatch queue
+// is closed and drained.
+func (node *Node) startDispatcher() {
+	defer close(node.dispatched)
+	var wg sync.WaitGroup
+	for i := 0; i < config.DispatchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range node.dispatch.c {
+				node.processQuery(item.(*KRPCMessage))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// startStorage runs writes of the storage queue, so that a slow store
+// never holds up protocol handling. Once all queries are dispatched
+// after the node stopped it runs the writes left and returns.
+func (node *Node) startStorage() {
+	for {
+		select {
+		case fn := <-node.storage.c:
+			fn.(func())()
+		case <-node.dispatched:
+			node.flushStorage()
+			return
+		}
+	}
+}
+
+// flushStorage runs the writes waiting in the storage queue.
+func (node *Node) flushStorage() {
+	for {
+		select {
+		case fn := <-node.storage.c:
+			fn.(func())()
+		default:
+			return
+		}
+	}
+}
+
+// processQuery answers a query with the handler registered for its method,
+// see Handle. The querying node is added to routing table.
+func (node *Node) processQuery(m *KRPCMessage) {
+	query, ok := m.ext.(*Query)
+	if !ok {
+		return
+	}
+	id, ok := query.a["id"].(string)
+	if !ok || len(id) != 20 {
+		node.sendError(m, ProtocolError, "invalid id")
+		return
+	}
+	queryNode := &Contact{
+		id:       Identifier(id),
+		ip:       m.addr.IP,
+		port:     m.addr.Port,
+		status:   Good,
+		lastSeen: time.Now(),
+	}
+
+	if handler := node.handlers.get(query.q); handler == nil {
+		node.sendError(m, MethodUnknown, "method unknown")
+	} else {
+		r, err := handler(node, m.addr, query.a)
+		if e, ok := err.(*KRPCError); ok {
+			node.sendError(m, e.Code, e.Message)
+		} else if err != nil {
+			log.Printf("error occurred while handling %s from %s: %v", query.q, queryNode, err)
+			node.sendError(m, ServerError, "server error")
+		} else if r != nil {
+			node.sendResponse(m, r)
+		}
+	}
+	node.table.insertNode(queryNode)
+}
+
+// sendResponse replies to query m with values r.
+func (node *Node) sendResponse(m *KRPCMessage, r map[string]interface{}) {
+	data, err := node.krpc.encodeResponse(m.t, node.info.id.String(), r)
+	if err != nil {
+		log.Printf("Error while encoding response")
+		return
+	}
+	node.transport.writeMsgUDP([]byte(data), m.addr)
+}
+
+// addPeer saves peer of infohash and reports it to the peer hook
+// through the storage queue.
+func (node *Node) addPeer(infohash Identifier, peer *net.UDPAddr) {
+	node.storage.push(func() {
+		if err := node.peers.Add(infohash, peer); err != nil {
+			log.Printf("error occurred while saving peer of %s: %v", infohash.HexString(), err)
+		}
+		if node.onPeer != nil {
+			node.onPeer(infohash, peer)
+		}
+	}, nil)
+}
+
+// track hands r to message broker so that its response is delivered,
+// it returns false if the node is stopping.
+func (node *Node) track(r *Request) bool {
+	select {
+	case node.reqC <- r:
+		return true
+	case <-node.ctx.Done():
+		return false
+	}
+}
+
+// sendError replies to query m with a KRPC error.
+func (node *Node) sendError(m *KRPCMessage, code int, msg string) {
+	data, err := node.krpc.encodeError(m.t, code, msg)
+	if err != nil {
+		log.Printf("Error while encoding error response")
+		return
+	}
+	node.transport.writeMsgUDP([]byte(data), m.addr)
+}
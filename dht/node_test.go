@@ -0,0 +1,206 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAnnouncePeer(t *testing.T) {
+	node := newTestNode(t)
+	conn := listenTest(t)
+	defer conn.Close()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	ih := RandomID()
+	announce := func(token string) string {
+		return sendTestQuery(t, node, conn, "announce_peer", map[string]interface{}{
+			"info_hash":    ih.String(),
+			"token":        token,
+			"port":         int64(1),
+			"implied_port": int64(1),
+		}).y
+	}
+
+	if y := announce("bogus"); y != "e" {
+		t.Errorf("expected error for bad token, got: %q", y)
+	}
+	if y := announce(node.tokens.generate(addr.IP)); y != "r" {
+		t.Errorf("expected response for good token, got: %q", y)
+	}
+
+	node.flushStorage()
+	peers, _ := node.peers.Get(ih, 10)
+	if len(peers) != 1 || peers[0].String() != addr.String() {
+		t.Errorf("expected peer %s with implied port, got: %v", addr, peers)
+	}
+}
+
+func TestQueryHandlers(t *testing.T) {
+	node := newTestNode(t)
+	conn := listenTest(t)
+	defer conn.Close()
+
+	msg := sendTestQuery(t, node, conn, "vote", nil)
+	if e, ok := msg.ext.(*Error); !ok || e.e[0] != int64(MethodUnknown) {
+		t.Errorf("expected method unknown error, got: %v", msg.ext)
+	}
+
+	node.Handle("vote", func(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"votes": args["vote"]}, nil
+	})
+	msg = sendTestQuery(t, node, conn, "vote", map[string]interface{}{"vote": int64(5)})
+	if r, ok := msg.ext.(*Response); !ok || r.r["votes"] != int64(5) || r.r["id"] != node.ID().String() {
+		t.Errorf("expected custom response, got: %v", msg.ext)
+	}
+
+	node.Handle("ping", func(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+		return nil, &KRPCError{GenericError, "go away"}
+	})
+	msg = sendTestQuery(t, node, conn, "ping", nil)
+	if e, ok := msg.ext.(*Error); !ok || e.e[0] != int64(GenericError) || e.e[1] != "go away" {
+		t.Errorf("expected generic error, got: %v", msg.ext)
+	}
+}
+
+func TestInfohashSeen(t *testing.T) {
+	node := newTestNode(t)
+	conn := listenTest(t)
+	defer conn.Close()
+
+	var events []InfohashSeen
+	node.OnInfohash(func(e InfohashSeen) { events = append(events, e) })
+	ih := RandomID()
+	for i := 0; i < 3; i++ {
+		sendTestQuery(t, node, conn, "get_peers", map[string]interface{}{"info_hash": ih.String()})
+	}
+	sendTestQuery(t, node, conn, "announce_peer", map[string]interface{}{
+		"info_hash": ih.String(),
+		"token":     node.tokens.generate(conn.LocalAddr().(*net.UDPAddr).IP),
+		"port":      int64(6881),
+	})
+	node.flushStorage()
+
+	if len(events) != 2 {
+		t.Fatalf("expected one event per method, got: %v", events)
+	}
+	if e := events[0]; e.Method != "get_peers" || !bytes.Equal(e.Infohash, ih) || e.Source.Addr().String() != conn.LocalAddr().String() {
+		t.Errorf("unexpected get_peers event: %+v", e)
+	}
+	if e := events[1]; e.Method != "announce_peer" || e.Port != 6881 {
+		t.Errorf("unexpected announce_peer event: %+v", e)
+	}
+}
+
+func TestInfohashSeenDropped(t *testing.T) {
+	node := newTestNode(t)
+	defer node.Stop()
+	node.storage = newQueue("storage", 1, DropNewest)
+	node.storage.push(func() {}, nil)
+
+	var events []InfohashSeen
+	node.OnInfohash(func(e InfohashSeen) { events = append(events, e) })
+	e := InfohashSeen{Infohash: RandomID(), Method: "get_peers", Time: time.Now()}
+	node.infohashSeen(e)
+	node.flushStorage()
+	node.infohashSeen(e)
+	node.flushStorage()
+
+	if len(events) != 1 {
+		t.Errorf("expected the dropped event to come through again, got: %d events", len(events))
+	}
+}
+
+func listenTest(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// sendTestQuery hands a query from conn to node and returns the reply.
+func sendTestQuery(t *testing.T, node *Node, conn *net.UDPConn, method string, args map[string]interface{}) *KRPCMessage {
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	a := map[string]interface{}{"id": RandomID().String()}
+	for k, v := range args {
+		a[k] = v
+	}
+	node.processQuery(&KRPCMessage{t: "1", y: "q", addr: addr, ext: &Query{q: method, a: a}})
+
+	buf := make([]byte, UDPPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := node.krpc.decode(string(buf[:n]), addr)
+	if err != nil || msg == nil {
+		t.Fatalf("expected a KRPC message, got: %q", buf[:n])
+	}
+	return msg
+}
+
+func TestNodeStop(t *testing.T) {
+	node := newTestNode(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	node.Start(ctx)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		node.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected all goroutines to return")
+	}
+
+	if node.track(NewRequest(NewContact(RandomID()), 1)) {
+		t.Error("expected stopped node not to track requests")
+	}
+}
+
+func TestSubnetLimitsOption(t *testing.T) {
+	node, err := NewNode(WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap(), WithSubnetLimits(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+
+	for i := 0; i < 2; i++ {
+		c := NewContact(RandomID())
+		c.ip, c.port = net.IPv4(10, 0, 0, byte(i+1)), 6881
+		node.table.insertNode(c)
+	}
+	if n := node.table.size(); n != 1 {
+		t.Errorf("expected 1 contact from the subnet, got: %d contacts", n)
+	}
+}
+
+func TestPing(t *testing.T) {
+	nodes := startTestNodes(t, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := nodes[0].Ping(ctx, nodes[1].Addr())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !bytes.Equal(id, nodes[1].ID()) {
+		t.Errorf("expected id %s, got: %s", nodes[1].ID().HexString(), id.HexString())
+	}
+}
+
+// newTestNode returns a node on localhost which has no bootstrap nodes.
+func newTestNode(t *testing.T) *Node {
+	node, err := NewNode(WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
@@ -0,0 +1,99 @@
+package dht
+
+import "net"
+
+// options configure a Node, see NewNode.
+type options struct {
+	id        Identifier
+	addr      *net.UDPAddr
+	bootstrap []string
+	paths     int
+	peers     PeerStore
+	store     NodeStore
+
+	subnetBucket int
+	subnetTable  int
+
+	handlers map[string]QueryHandler
+	inbound  []InboundMiddleware
+	outbound []OutboundMiddleware
+
+	onInfohash []func(InfohashSeen)
+	onPeer     func(infohash Identifier, peer *net.UDPAddr)
+}
+
+// An Option configures a Node.
+type Option func(*options)
+
+// WithID sets id of the node, a random id is used by default.
+// If the node has a NodeStore its snapshot is restored.
+func WithID(id Identifier) Option {
+	return func(o *options) { o.id = id }
+}
+
+// WithListenAddr sets the UDP address the node listens on,
+// by default it listens on a random port.
+func WithListenAddr(addr *net.UDPAddr) Option {
+	return func(o *options) { o.addr = addr }
+}
+
+// WithBootstrap sets bootstrap sources of the node, see Bootstrappers
+// and SourceFile for the formats.
+func WithBootstrap(sources ...string) Option {
+	return func(o *options) { o.bootstrap = sources }
+}
+
+// WithDisjointPaths sets the number of disjoint paths of each lookup,
+// more paths resist Sybil attacks.
+func WithDisjointPaths(n int) Option {
+	return func(o *options) { o.paths = n }
+}
+
+// WithSubnetLimits sets the max number of contacts from the same subnet
+// a bucket and the whole routing table may hold, 0 means no limit.
+func WithSubnetLimits(bucket, table int) Option {
+	return func(o *options) { o.subnetBucket, o.subnetTable = bucket, table }
+}
+
+// WithPeerStore sets where peers are kept, peers are kept in memory
+// by default.
+func WithPeerStore(s PeerStore) Option {
+	return func(o *options) { o.peers = s }
+}
+
+// WithNodeStore sets where snapshots of the node are saved,
+// by default nothing is saved.
+func WithNodeStore(s NodeStore) Option {
+	return func(o *options) { o.store = s }
+}
+
+// WithQueryHandler registers handler for queries of method, see Node.Handle.
+func WithQueryHandler(method string, handler QueryHandler) Option {
+	return func(o *options) {
+		if o.handlers == nil {
+			o.handlers = make(map[string]QueryHandler)
+		}
+		o.handlers[method] = handler
+	}
+}
+
+// WithInbound appends fns to inbound middleware, see Node.UseInbound.
+func WithInbound(fns ...InboundMiddleware) Option {
+	return func(o *options) { o.inbound = append(o.inbound, fns...) }
+}
+
+// WithOutbound appends fns to outbound middleware, see Node.UseOutbound.
+func WithOutbound(fns ...OutboundMiddleware) Option {
+	return func(o *options) { o.outbound = append(o.outbound, fns...) }
+}
+
+// WithInfohashHook subscribes fn to InfohashSeen events, see Node.OnInfohash.
+func WithInfohashHook(fn func(InfohashSeen)) Option {
+	return func(o *options) { o.onInfohash = append(o.onInfohash, fn) }
+}
+
+// WithPeerHook sets fn to be called with every peer that is found by a
+// get_peers lookup or announced to the node.
+func WithPeerHook(fn func(infohash Identifier, peer *net.UDPAddr)) Option {
+	return func(o *options) { o.onPeer = fn }
+}
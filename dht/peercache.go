@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// peerCacheEntry is the result of a get_peers lookup, done is closed
+// when the lookup finishes.
+type peerCacheEntry struct {
+	key     string
+	result  *LookupResult
+	err     error
+	expires time.Time
+	done    chan struct{}
+	elem    *list.Element
+}
+
+// peerCache caches results of get_peers lookups by infohash for ttl and
+// holds at most size results, least recently used ones are evicted first.
+// Concurrent lookups of one infohash are collapsed into a single lookup.
+// Each node has a cache of its own so peers it finds reach its own peer
+// store and hook, lookups are cancelled when ctx of the node is done.
+type peerCache struct {
+	ctx  context.Context
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*peerCacheEntry
+	lru     *list.List // completed entries, most recently used first
+}
+
+func newPeerCache(ctx context.Context, ttl time.Duration, size int) *peerCache {
+	return &peerCache{
+		ctx:     ctx,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*peerCacheEntry),
+		lru:     list.New(),
+	}
+}
+
+// get returns the cached result for key. If there's none, the first caller
+// starts fetch and every caller, the first one included, waits for its
+// result or until its own ctx is done. fetch gets a context derived from
+// ctx of the cache so a caller giving up doesn't cancel the lookup for
+// others. Failed lookups are not cached.
+func (c *peerCache) get(ctx context.Context, key string, fetch func(context.Context) (*LookupResult, error)) (*LookupResult, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && e.elem != nil && time.Now().After(e.expires) {
+		c.remove(e)
+		ok = false
+	}
+	if ok {
+		if e.elem != nil {
+			c.lru.MoveToFront(e.elem)
+		}
+	} else {
+		e = &peerCacheEntry{key: key, done: make(chan struct{})}
+		c.entries[key] = e
+		go c.fetch(e, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return e.result, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch runs fetch for entry e and caches its result.
+func (c *peerCache) fetch(e *peerCacheEntry, fetch func(context.Context) (*LookupResult, error)) {
+	ctx, cancel := context.WithTimeout(c.ctx, config.LookupTimeout)
+	e.result, e.err = fetch(ctx)
+	cancel()
+
+	c.mu.Lock()
+	if e.err != nil {
+		delete(c.entries, e.key)
+	} else {
+		e.expires = time.Now().Add(c.ttl)
+		e.elem = c.lru.PushFront(e)
+		for c.lru.Len() > c.size {
+			c.remove(c.lru.Back().Value.(*peerCacheEntry))
+		}
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
+
+// remove drops a completed entry, callers must hold c.mu.
+func (c *peerCache) remove(e *peerCacheEntry) {
+	c.lru.Remove(e.elem)
+	if c.entries[e.key] == e {
+		delete(c.entries, e.key)
+	}
+}
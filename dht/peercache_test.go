@@ -0,0 +1,99 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeerCacheCollapses(t *testing.T) {
+	cache := newPeerCache(context.Background(), time.Minute, 10)
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (*LookupResult, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return &LookupResult{Peers: []*net.UDPAddr{{IP: net.IPv4(127, 0, 0, 1), Port: 6881}}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := cache.get(context.Background(), "ih", fetch)
+			if err != nil || len(result.Peers) != 1 {
+				t.Errorf("expected 1 peer, got: %v, %v", result, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	cache.get(context.Background(), "ih", fetch)
+	if fetches != 1 {
+		t.Errorf("expected 1 lookup, got: %d lookups", fetches)
+	}
+}
+
+func TestPeerCacheExpiry(t *testing.T) {
+	cache := newPeerCache(context.Background(), time.Millisecond, 1)
+	fetches := 0
+	fetch := func(ctx context.Context) (*LookupResult, error) {
+		fetches++
+		return &LookupResult{}, nil
+	}
+
+	cache.get(context.Background(), "a", fetch)
+	time.Sleep(5 * time.Millisecond)
+	cache.get(context.Background(), "a", fetch)
+	cache.get(context.Background(), "b", fetch)
+	if fetches != 3 || len(cache.entries) != 1 {
+		t.Errorf("expected 3 lookups and 1 entry, got: %d lookups and %d entries", fetches, len(cache.entries))
+	}
+
+	fail := func(ctx context.Context) (*LookupResult, error) { return nil, errors.New("failed") }
+	if _, err := cache.get(context.Background(), "c", fail); err == nil {
+		t.Errorf("expected error, got: nil")
+	}
+	if _, ok := cache.entries["c"]; ok {
+		t.Errorf("expected failed lookup not to be cached")
+	}
+}
+
+func TestPeerCacheContexts(t *testing.T) {
+	nodeCtx, stop := context.WithCancel(context.Background())
+	cache := newPeerCache(nodeCtx, time.Minute, 10)
+	started := make(chan struct{})
+	var once sync.Once
+	fetch := func(ctx context.Context) (*LookupResult, error) {
+		// the second get may start a lookup of its own
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	// the first caller gives up without waiting for the lookup
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := cache.get(ctx, "ih", fetch)
+		done <- err
+	}()
+	<-started
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected the caller's context error, got: %v", err)
+	}
+
+	// the lookup ends when the node stops
+	stop()
+	if _, err := cache.get(context.Background(), "ih", fetch); err != context.Canceled {
+		t.Errorf("expected the lookup to be cancelled with the node, got: %v", err)
+	}
+}
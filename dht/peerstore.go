@@ -0,0 +1,270 @@
+package dht
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"net"
+	"sync"
+	"time"
+)
+
+// PeerStore stores peers of infohashes, both the ones announced to us and
+// the ones found by our own get_peers lookups. Peers expire some time
+// after they were last added.
+type PeerStore interface {
+	// Add stores peer of infohash, adding a known peer again refreshes it.
+	Add(infohash Identifier, peer *net.UDPAddr) error
+
+	// Get returns at most n live peers of infohash.
+	Get(infohash Identifier, n int) ([]*net.UDPAddr, error)
+
+	// Expire drops expired peers and returns how many were dropped.
+	Expire() (int, error)
+
+	// Count returns the number of live peers of all infohashes.
+	Count() (int, error)
+}
+
+// compactPeer encodes peer into compact format.
+func compactPeer(peer *net.UDPAddr) string {
+	buf := bytes.NewBuffer(nil)
+	encodeEndpoint(buf, peer.IP, peer.Port)
+	return buf.String()
+}
+
+// compactPeers encodes peers into compact format for get_peers replies.
+func compactPeers(peers []*net.UDPAddr) []string {
+	ret := make([]string, len(peers))
+	for i, p := range peers {
+		ret[i] = compactPeer(p)
+	}
+	return ret
+}
+
+// MemoryPeerStore keeps peers in memory. It holds at most max peers per
+// infohash, each of them expires ttl after it was last added.
+type MemoryPeerStore struct {
+	max int
+	ttl time.Duration
+
+	mu    sync.Mutex
+	peers map[string]map[string]time.Time // infohash -> compact peer -> expiry
+}
+
+// NewMemoryPeerStore returns an empty in-memory peer store.
+func NewMemoryPeerStore(max int, ttl time.Duration) *MemoryPeerStore {
+	return &MemoryPeerStore{
+		max:   max,
+		ttl:   ttl,
+		peers: make(map[string]map[string]time.Time),
+	}
+}
+
+// Add stores peer for infohash, if infohash is full the peer
+// closest to expiry makes room.
+func (s *MemoryPeerStore) Add(infohash Identifier, peer *net.UDPAddr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers, ok := s.peers[infohash.String()]
+	if !ok {
+		peers = make(map[string]time.Time)
+		s.peers[infohash.String()] = peers
+	}
+	key := compactPeer(peer)
+	if _, ok := peers[key]; !ok && len(peers) >= s.max {
+		var oldest string
+		for p, expiry := range peers {
+			if oldest == "" || expiry.Before(peers[oldest]) {
+				oldest = p
+			}
+		}
+		delete(peers, oldest)
+	}
+	peers[key] = time.Now().Add(s.ttl)
+	return nil
+}
+
+func (s *MemoryPeerStore) Get(infohash Identifier, n int) ([]*net.UDPAddr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret []*net.UDPAddr
+	now := time.Now()
+	for peer, expiry := range s.peers[infohash.String()] {
+		if len(ret) == n {
+			break
+		}
+		if expiry.After(now) {
+			ret = append(ret, peerAddr(peer))
+		}
+	}
+	return ret, nil
+}
+
+func (s *MemoryPeerStore) Expire() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := 0
+	now := time.Now()
+	for ih, peers := range s.peers {
+		for peer, expiry := range peers {
+			if !expiry.After(now) {
+				delete(peers, peer)
+				dropped++
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.peers, ih)
+		}
+	}
+	return dropped, nil
+}
+
+func (s *MemoryPeerStore) Count() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	now := time.Now()
+	for _, peers := range s.peers {
+		for _, expiry := range peers {
+			if expiry.After(now) {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// SQLitePeerStore keeps peers in the Peers table of a SQLite database,
+// see db/sqlite.sql. Like MemoryPeerStore it holds at most max peers per
+// infohash which expire ttl after they were last added.
+type SQLitePeerStore struct {
+	db  *sql.DB
+	max int
+	ttl time.Duration
+}
+
+// NewSQLitePeerStore returns a peer store on db, the Peers table is created
+// if it doesn't exist and migrated if it still has the old base64 layout.
+func NewSQLitePeerStore(db *sql.DB, max int, ttl time.Duration) (*SQLitePeerStore, error) {
+	s := &SQLitePeerStore{db: db, max: max, ttl: ttl}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+const peersTable = `CREATE TABLE IF NOT EXISTS Peers
+(
+    infohash VARCHAR(40) NOT NULL,
+    ip TEXT NOT NULL,
+    port INTEGER NOT NULL,
+    expires INTEGER NOT NULL,
+    UNIQUE (infohash, ip, port)
+)`
+
+// migrate creates Peers table, rows of the old layout which kept compact
+// peers as base64 text are converted. The migration runs in a single
+// transaction so a failure leaves the old table as it was.
+func (s *SQLitePeerStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old []struct {
+		infohash string
+		peer     string
+	}
+	if rows, err := tx.Query("SELECT infohash, peers FROM Peers"); err == nil {
+		for rows.Next() {
+			var r struct {
+				infohash string
+				peer     string
+			}
+			if rows.Scan(&r.infohash, &r.peer) == nil {
+				old = append(old, r)
+			}
+		}
+		rows.Close()
+		if _, err := tx.Exec("DROP TABLE Peers"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(peersTable); err != nil {
+		return err
+	}
+	for _, r := range old {
+		data, err := base64.StdEncoding.DecodeString(r.peer)
+		if addr := peerAddr(string(data)); err == nil && addr != nil {
+			if err := s.add(tx, HexToID(r.infohash), addr); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// execer is a *sql.DB or a *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Add stores peer for infohash, if infohash is full the peers
+// closest to expiry make room.
+func (s *SQLitePeerStore) Add(infohash Identifier, peer *net.UDPAddr) error {
+	return s.add(s.db, infohash, peer)
+}
+
+func (s *SQLitePeerStore) add(db execer, infohash Identifier, peer *net.UDPAddr) error {
+	ih := infohash.HexString()
+	_, err := db.Exec("INSERT OR REPLACE INTO Peers(infohash, ip, port, expires) VALUES(?, ?, ?, ?)",
+		ih, peer.IP.String(), peer.Port, time.Now().Add(s.ttl).UnixNano())
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`DELETE FROM Peers WHERE infohash = ? AND rowid NOT IN
+		(SELECT rowid FROM Peers WHERE infohash = ? ORDER BY expires DESC LIMIT ?)`, ih, ih, s.max)
+	return err
+}
+
+func (s *SQLitePeerStore) Get(infohash Identifier, n int) ([]*net.UDPAddr, error) {
+	rows, err := s.db.Query("SELECT ip, port FROM Peers WHERE infohash = ? AND expires > ? ORDER BY expires DESC LIMIT ?",
+		infohash.HexString(), time.Now().UnixNano(), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []*net.UDPAddr
+	for rows.Next() {
+		var ip string
+		var port int
+		if err := rows.Scan(&ip, &port); err != nil {
+			return nil, err
+		}
+		ret = append(ret, &net.UDPAddr{IP: net.ParseIP(ip), Port: port})
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQLitePeerStore) Expire() (int, error) {
+	res, err := s.db.Exec("DELETE FROM Peers WHERE expires <= ?", time.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLitePeerStore) Count() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM Peers WHERE expires > ?", time.Now().UnixNano()).Scan(&count)
+	return count, err
+}
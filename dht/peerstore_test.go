@@ -0,0 +1,120 @@
+package dht
+
+import (
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" //sqlite driver
+)
+
+// testPeerStores runs fn against every PeerStore backend.
+func testPeerStores(t *testing.T, max int, ttl time.Duration, fn func(*testing.T, PeerStore)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryPeerStore(max, ttl))
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		// every connection would get its own in-memory database
+		db.SetMaxOpenConns(1)
+		store, err := NewSQLitePeerStore(db, max, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn(t, store)
+	})
+}
+
+func testPeer(i int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)).To4(), Port: 6881 + i}
+}
+
+func TestPeerStoreCap(t *testing.T) {
+	testPeerStores(t, 2, time.Minute, func(t *testing.T, store PeerStore) {
+		ih := RandomID()
+		for i := 1; i <= 3; i++ {
+			store.Add(ih, testPeer(i))
+			time.Sleep(time.Millisecond)
+		}
+
+		peers, err := store.Get(ih, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(peers) != 2 {
+			t.Fatalf("expected 2 peers, got: %v", peers)
+		}
+		for _, p := range peers {
+			if p.String() == testPeer(1).String() {
+				t.Errorf("expected oldest peer to be dropped, got: %v", peers)
+			}
+		}
+		if n, _ := store.Count(); n != 2 {
+			t.Errorf("expected 2 peers counted, got: %d", n)
+		}
+	})
+}
+
+func TestPeerStoreRefresh(t *testing.T) {
+	testPeerStores(t, 10, time.Minute, func(t *testing.T, store PeerStore) {
+		ih := RandomID()
+		store.Add(ih, testPeer(1))
+		store.Add(ih, testPeer(1))
+		store.Add(RandomID(), testPeer(1))
+
+		if peers, _ := store.Get(ih, 10); len(peers) != 1 {
+			t.Errorf("expected peer to be stored once, got: %v", peers)
+		}
+		if peers, _ := store.Get(ih, 0); len(peers) != 0 {
+			t.Errorf("expected no peers for n = 0, got: %v", peers)
+		}
+		if n, _ := store.Count(); n != 2 {
+			t.Errorf("expected 2 peers counted, got: %d", n)
+		}
+	})
+}
+
+func TestPeerStoreExpire(t *testing.T) {
+	testPeerStores(t, 10, time.Millisecond, func(t *testing.T, store PeerStore) {
+		ih := RandomID()
+		store.Add(ih, testPeer(1))
+		time.Sleep(5 * time.Millisecond)
+
+		if peers, _ := store.Get(ih, 10); len(peers) != 0 {
+			t.Errorf("expected no live peers, got: %v", peers)
+		}
+		if n, _ := store.Count(); n != 0 {
+			t.Errorf("expected no peers counted, got: %d", n)
+		}
+		if n, err := store.Expire(); err != nil || n != 1 {
+			t.Errorf("expected 1 peer expired, got: %d, %v", n, err)
+		}
+	})
+}
+
+func TestSQLitePeerStoreMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ih := RandomID()
+	db.Exec("CREATE TABLE Peers (id integer PRIMARY KEY, infohash VARCHAR(40) not null, peers TEXT, ctime TIMESTAMP)")
+	db.Exec("INSERT INTO Peers(infohash, peers) VALUES(?, ?)", ih.HexString(), "CgAAAhrj") // 10.0.0.2:6883
+
+	store, err := NewSQLitePeerStore(db, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers, _ := store.Get(ih, 10)
+	if len(peers) != 1 || peers[0].String() != testPeer(2).String() {
+		t.Errorf("expected migrated peer %s, got: %v", testPeer(2), peers)
+	}
+}
@@ -0,0 +1,127 @@
+package dht
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// Drop policies of a full queue.
+const (
+	// DropNewest drops the item being pushed.
+	DropNewest = iota
+	// DropOldest drops the oldest queued item to make room.
+	DropOldest
+	// Block makes the pusher wait for room.
+	Block
+)
+
+var policyNames = map[int]string{
+	DropNewest: "drop_newest",
+	DropOldest: "drop_oldest",
+	Block:      "block",
+}
+
+// queue is a bounded queue between two stages of a node. Messages flow
+// from the UDP listener through the read queue to the decoder, through
+// the message queue to the broker, through the dispatch queue to the query
+// workers, and writes of peers and infohashes go through the storage queue.
+type queue struct {
+	name    string
+	policy  int
+	c       chan interface{}
+	dropped uint64
+}
+
+func newQueue(name string, size, policy int) *queue {
+	return &queue{name: name, policy: policy, c: make(chan interface{}, size)}
+}
+
+// push adds item to q according to its drop policy, it returns false
+// if item was dropped. A blocked push gives up when done is closed.
+func (q *queue) push(item interface{}, done <-chan struct{}) bool {
+	switch q.policy {
+	case Block:
+		select {
+		case q.c <- item:
+			return true
+		default:
+		}
+		select {
+		case q.c <- item:
+			return true
+		case <-done:
+			atomic.AddUint64(&q.dropped, 1)
+			return false
+		}
+	case DropOldest:
+		for {
+			select {
+			case q.c <- item:
+				return true
+			default:
+			}
+			select {
+			case <-q.c:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.c <- item:
+			return true
+		default:
+			atomic.AddUint64(&q.dropped, 1)
+			return false
+		}
+	}
+}
+
+// QueueStats describes a queue of a node.
+type QueueStats struct {
+	Name     string `json:"name"`
+	Policy   string `json:"policy"`
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+func (q *queue) stats() QueueStats {
+	return QueueStats{
+		Name:     q.name,
+		Policy:   policyNames[q.policy],
+		Depth:    len(q.c),
+		Capacity: cap(q.c),
+		Dropped:  atomic.LoadUint64(&q.dropped),
+	}
+}
+
+// QueueStats returns stats of all queues of node.
+func (node *Node) QueueStats() []QueueStats {
+	return []QueueStats{
+		node.reads.stats(),
+		node.messages.stats(),
+		node.dispatch.stats(),
+		node.storage.stats(),
+	}
+}
+
+// running holds started nodes, their queues are published
+// by expvar as dht_queues keyed by node id.
+var running = struct {
+	sync.Mutex
+	nodes map[*Node]bool
+}{nodes: make(map[*Node]bool)}
+
+func init() {
+	expvar.Publish("dht_queues", expvar.Func(func() interface{} {
+		running.Lock()
+		defer running.Unlock()
+		queues := make(map[string][]QueueStats)
+		for node := range running.nodes {
+			queues[node.info.id.HexString()] = node.QueueStats()
+		}
+		return queues
+	}))
+}
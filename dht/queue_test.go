@@ -0,0 +1,34 @@
+package dht
+
+import "testing"
+
+func TestQueuePolicies(t *testing.T) {
+	q := newQueue("test", 2, DropNewest)
+	for i := 1; i <= 3; i++ {
+		q.push(i, nil)
+	}
+	if a, b := <-q.c, <-q.c; a != 1 || b != 2 || q.stats().Dropped != 1 {
+		t.Errorf("expected newest item dropped, got: %v, %v, %+v", a, b, q.stats())
+	}
+
+	q = newQueue("test", 2, DropOldest)
+	for i := 1; i <= 3; i++ {
+		q.push(i, nil)
+	}
+	if a, b := <-q.c, <-q.c; a != 2 || b != 3 || q.stats().Dropped != 1 {
+		t.Errorf("expected oldest item dropped, got: %v, %v, %+v", a, b, q.stats())
+	}
+
+	q = newQueue("test", 1, Block)
+	done := make(chan struct{})
+	close(done)
+	if !q.push(1, done) {
+		t.Error("expected push into empty queue to succeed")
+	}
+	if q.push(2, done) {
+		t.Error("expected blocked push to give up when done is closed")
+	}
+	if s := q.stats(); s.Depth != 1 || s.Capacity != 1 || s.Policy != "block" {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,343 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RouterConfig configures a Router.
+type RouterConfig struct {
+	// MaxContacts caps the number of contacts a router holds, new
+	// contacts are dropped once it is full.
+	MaxContacts int
+
+	// VerifyInterval is how often a contact is pinged again, a contact
+	// is dropped after MaxFails unanswered pings in a row.
+	VerifyInterval time.Duration
+	MaxFails       int
+
+	// VerifyWorkers is the number of pings in flight, VerifyQueueSize
+	// is the number of contacts waiting for a ping.
+	VerifyWorkers   int
+	VerifyQueueSize int
+}
+
+// DefaultRouterConfig returns the configuration of a router
+// for a network of about a million nodes.
+func DefaultRouterConfig() RouterConfig {
+	return RouterConfig{
+		MaxContacts:     1000000,
+		VerifyInterval:  15 * time.Minute,
+		MaxFails:        2,
+		VerifyWorkers:   64,
+		VerifyQueueSize: 4096,
+	}
+}
+
+// routerEntry is a contact of a router.
+type routerEntry struct {
+	id       Identifier
+	addr     *net.UDPAddr
+	verified time.Time
+	fails    int
+}
+
+// routerStore holds contacts in buckets by the first 16 bits of their id,
+// the store is flat so it holds far more than k contacts near any id.
+type routerStore struct {
+	mu      sync.RWMutex
+	buckets [1 << 16][]*routerEntry
+	byID    map[string]*routerEntry
+}
+
+func newRouterStore() *routerStore {
+	return &routerStore{byID: make(map[string]*routerEntry)}
+}
+
+func prefix16(id Identifier) int {
+	return int(id[0])<<8 | int(id[1])
+}
+
+func (s *routerStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byID)
+}
+
+func (s *routerStore) get(id Identifier) (routerEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.byID[string(id)]
+	if !ok {
+		return routerEntry{}, false
+	}
+	return *e, true
+}
+
+// verified records that id answered a ping from addr, it returns false
+// if the store is full.
+func (s *routerStore) verified(id Identifier, addr *net.UDPAddr, max int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.byID[string(id)]; ok {
+		e.addr, e.verified, e.fails = addr, time.Now(), 0
+		return true
+	}
+	if len(s.byID) >= max {
+		return false
+	}
+	e := &routerEntry{id: id, addr: addr, verified: time.Now()}
+	s.byID[string(id)] = e
+	p := prefix16(id)
+	s.buckets[p] = append(s.buckets[p], e)
+	return true
+}
+
+// failed records an unanswered ping to id, the contact is removed
+// after maxFails of them.
+func (s *routerStore) failed(id Identifier, maxFails int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.byID[string(id)]
+	if !ok {
+		return
+	}
+	if e.fails++; e.fails < maxFails {
+		return
+	}
+	delete(s.byID, string(id))
+	p := prefix16(id)
+	b := s.buckets[p]
+	for i := range b {
+		if b[i] == e {
+			b[i] = b[len(b)-1]
+			s.buckets[p] = b[:len(b)-1]
+			break
+		}
+	}
+}
+
+// stale returns contacts verified before t.
+func (s *routerStore) stale(t time.Time) []routerEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []routerEntry
+	for _, e := range s.byID {
+		if e.verified.Before(t) {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+// closest returns the k contacts closest to target. Every id in the
+// bucket of prefix p^d is at a distance whose first 16 bits are d, so
+// visiting buckets by increasing d visits contacts by increasing distance
+// and the search stops at the first bucket which completes k contacts.
+func (s *routerStore) closest(target Identifier, k int) []*Contact {
+	s.mu.RLock()
+	var found []*routerEntry
+	p := prefix16(target)
+	for d := 0; d < len(s.buckets) && len(found) < k; d++ {
+		found = append(found, s.buckets[p^d]...)
+	}
+	contacts := make([]*Contact, len(found))
+	for i, e := range found {
+		contacts[i] = &Contact{id: e.id, ip: e.addr.IP, port: e.addr.Port, status: Good, lastSeen: e.verified}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(contacts, func(i, j int) bool {
+		return bytes.Compare(distance(contacts[i].id, target), distance(contacts[j].id, target)) < 0
+	})
+	if len(contacts) > k {
+		contacts = contacts[:k]
+	}
+	return contacts
+}
+
+// RouterStats describes the contacts of a router.
+type RouterStats struct {
+	Contacts int   `json:"contacts"`
+	Pending  int   `json:"pending"`
+	Verified int64 `json:"verified"`
+	Failed   int64 `json:"failed"`
+	Dropped  int64 `json:"dropped"`
+}
+
+// Router is a node which serves find_node from a large flat store of
+// verified contacts, to bootstrap other nodes into a network like
+// router.bittorrent.com does. It answers only ping and find_node and
+// stores no peers. Nodes which query the router and nodes its routing
+// table learns of are pinged before they are handed out.
+type Router struct {
+	cfg   RouterConfig
+	node  *Node
+	store *routerStore
+
+	pending chan *Contact
+	mu      sync.Mutex
+	queued  map[string]bool
+	stats   RouterStats
+}
+
+// NewRouter returns a router whose node is configured by opts.
+func NewRouter(cfg RouterConfig, opts ...Option) (*Router, error) {
+	r := &Router{
+		cfg:     cfg,
+		store:   newRouterStore(),
+		pending: make(chan *Contact, cfg.VerifyQueueSize),
+		queued:  make(map[string]bool),
+	}
+	opts = append(append([]Option(nil), opts...),
+		WithQueryHandler("ping", r.handlePing),
+		WithQueryHandler("find_node", r.handleFindNode),
+		WithQueryHandler("get_peers", nil),
+		WithQueryHandler("announce_peer", nil),
+	)
+	node, err := NewNode(opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.node = node
+	return r, nil
+}
+
+// Node returns the node of the router.
+func (r *Router) Node() *Node {
+	return r.node
+}
+
+// Start starts the node of the router and the pings which verify
+// contacts, the router stops when ctx is done or Stop is called.
+func (r *Router) Start(ctx context.Context) {
+	r.node.Start(ctx)
+	for i := 0; i < r.cfg.VerifyWorkers; i++ {
+		r.node.goroutine(r.verify)
+	}
+	r.node.goroutine(r.refresh)
+}
+
+// Stop stops the node of the router.
+func (r *Router) Stop() {
+	r.node.Stop()
+}
+
+// Stats returns the number of contacts and pings of the router.
+func (r *Router) Stats() RouterStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.Contacts = r.store.len()
+	s.Pending = len(r.queued)
+	return s
+}
+
+// candidate queues a ping to c unless it was verified at its
+// address within VerifyInterval. Only IPv4 contacts are kept since
+// they are handed out as compact IPv4 nodes.
+func (r *Router) candidate(c *Contact) {
+	if c.ip.To4() == nil {
+		return
+	}
+	if e, ok := r.store.get(c.id); ok && e.addr.IP.Equal(c.ip) && e.addr.Port == c.port &&
+		time.Since(e.verified) < r.cfg.VerifyInterval {
+		return
+	}
+	key := string(c.id) + c.Addr().String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queued[key] {
+		return
+	}
+	select {
+	case r.pending <- c:
+		r.queued[key] = true
+	default:
+		r.stats.Dropped++
+	}
+}
+
+// verify pings queued contacts, a contact goes into the store once
+// it answers with the id it claimed.
+func (r *Router) verify() {
+	for {
+		select {
+		case c := <-r.pending:
+			id, err := r.node.Ping(r.node.ctx, c.Addr())
+			r.mu.Lock()
+			delete(r.queued, string(c.id)+c.Addr().String())
+			if err == nil && bytes.Equal(id, c.id) {
+				r.stats.Verified++
+			} else {
+				r.stats.Failed++
+			}
+			r.mu.Unlock()
+
+			// a contact which claims the id of another address must
+			// not count against the contact at that address
+			if err == nil && bytes.Equal(id, c.id) {
+				r.store.verified(c.id, c.Addr(), r.cfg.MaxContacts)
+			} else if e, ok := r.store.get(c.id); ok && r.node.ctx.Err() == nil &&
+				e.addr.IP.Equal(c.ip) && e.addr.Port == c.port {
+				r.store.failed(c.id, r.cfg.MaxFails)
+			}
+		case <-r.node.ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh queues contacts of the routing table and contacts which
+// were verified more than VerifyInterval ago.
+func (r *Router) refresh() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, c := range r.node.Contacts() {
+				r.candidate(c)
+			}
+			for _, e := range r.store.stale(time.Now().Add(-r.cfg.VerifyInterval)) {
+				r.candidate(&Contact{id: e.id, ip: e.addr.IP, port: e.addr.Port})
+			}
+		case <-r.node.ctx.Done():
+			return
+		}
+	}
+}
+
+// queried queues the node which sent a query.
+func (r *Router) queried(addr *net.UDPAddr, args map[string]interface{}) {
+	if id, ok := args["id"].(string); ok && len(id) == 20 {
+		r.candidate(&Contact{id: Identifier(id), ip: addr.IP, port: addr.Port})
+	}
+}
+
+func (r *Router) handlePing(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+	r.queried(addr, args)
+	return map[string]interface{}{}, nil
+}
+
+func (r *Router) handleFindNode(node *Node, addr *net.UDPAddr, args map[string]interface{}) (map[string]interface{}, error) {
+	target, ok := args["target"].(string)
+	if !ok || len(target) != 20 {
+		return nil, &KRPCError{ProtocolError, "invalid target"}
+	}
+	r.queried(addr, args)
+	closest := r.store.closest(Identifier(target), maxNodesPerBucket)
+	if len(closest) == 0 {
+		// nothing verified yet, e.g. right after start
+		for _, c := range node.table.findLocalClosest(Identifier(target)) {
+			if c.ip.To4() != nil {
+				closest = append(closest, c)
+			}
+		}
+	}
+	return map[string]interface{}{"nodes": string(encodeContacts(closest))}, nil
+}
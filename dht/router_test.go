@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRouterStoreClosest(t *testing.T) {
+	s := newRouterStore()
+	var ids []Identifier
+	for i := 0; i < 1000; i++ {
+		id := RandomID()
+		ids = append(ids, id)
+		s.verified(id, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: i + 1}, 1000)
+	}
+	if s.verified(RandomID(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1}, 1000) {
+		t.Errorf("expected a full store to drop new contacts")
+	}
+
+	target := RandomID()
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(distance(ids[i], target), distance(ids[j], target)) < 0
+	})
+	closest := s.closest(target, 8)
+	if len(closest) != 8 {
+		t.Fatalf("expected 8 contacts, got: %d contacts", len(closest))
+	}
+	for i, c := range closest {
+		if !bytes.Equal(c.id, ids[i]) {
+			t.Errorf("expected %s at %d, got: %s", ids[i].HexString(), i, c.id.HexString())
+		}
+	}
+
+	s.failed(ids[0], 2)
+	s.failed(ids[0], 2)
+	if c := s.closest(target, 1); bytes.Equal(c[0].id, ids[0]) {
+		t.Errorf("expected contact to be removed after 2 failed pings")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	cfg := DefaultRouterConfig()
+	cfg.VerifyWorkers = 2
+	r, err := NewRouter(cfg, WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start(context.Background())
+	defer r.Stop()
+
+	nodes := startTestNodes(t, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, node := range nodes {
+		if _, err := node.Ping(ctx, r.Node().Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for r.store.len() < len(nodes) {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected %d verified contacts, got: %+v", len(nodes), r.Stats())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	client := newTestNode(t)
+	client.table.insertNode(&Contact{id: r.Node().ID(), ip: net.IPv4(127, 0, 0, 1), port: r.Node().Addr().Port})
+	client.Start(context.Background())
+	defer client.Stop()
+	result, err := client.FindNode(ctx, RandomID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		found := false
+		for _, c := range result.Closest {
+			found = found || bytes.Equal(c.id, node.ID())
+		}
+		if !found {
+			t.Errorf("expected %s among closest nodes, got: %v", node.ID().HexString(), result.Closest)
+		}
+	}
+
+	// the router pings only nodes which sent ping or find_node, so
+	// the reply is the only packet conn receives
+	conn := listenTest(t)
+	defer conn.Close()
+	msg := sendTestQuery(t, r.Node(), conn, "get_peers", map[string]interface{}{"info_hash": RandomID().String()})
+	if e, ok := msg.ext.(*Error); !ok || e.e[0] != int64(MethodUnknown) {
+		t.Errorf("expected method unknown error, got: %v", msg.ext)
+	}
+}
+
+func TestRouterSkipsIPv6(t *testing.T) {
+	r, err := NewRouter(DefaultRouterConfig(), WithListenAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}), WithBootstrap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	r.candidate(&Contact{id: RandomID(), ip: net.ParseIP("2001:db8::1"), port: 6881})
+	r.candidate(&Contact{id: RandomID(), ip: net.ParseIP("::ffff:10.0.0.1"), port: 6881})
+	if n := len(r.pending); n != 1 {
+		t.Errorf("expected only the IPv4 contact to be queued, got: %d contacts", n)
+	}
+}
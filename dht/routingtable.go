@@ -1,13 +1,12 @@
-package main
+package dht
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
-	"io"
 	"log"
 	"math/big"
 	"math/rand"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/rliu054/magnetsearch/util"
@@ -40,7 +39,12 @@ func NewBucket(min, max *big.Int) *Bucket {
 func (b *Bucket) contains(node *Contact) bool {
 	for i, n := range b.nodes {
 		if n.id.String() == node.id.String() {
-			b.nodes[i] = node
+			// an id showing up from another subnet doesn't replace
+			// the contact we already know
+			if subnet(n.ip) == subnet(node.ip) {
+				node.inherit(n)
+				b.nodes[i] = node
+			}
 			b.lastUpdated = time.Now()
 			return true
 		}
@@ -48,12 +52,23 @@ func (b *Bucket) contains(node *Contact) bool {
 	return false
 }
 
+// countSubnet returns the number of contacts in bucket from subnet s.
+func (b *Bucket) countSubnet(s string) int {
+	count := 0
+	for _, n := range b.nodes {
+		if subnet(n.ip) == s {
+			count++
+		}
+	}
+	return count
+}
+
 func (b *Bucket) insert(node *Contact) {
 	b.nodes = append(b.nodes, node)
 	b.lastUpdated = time.Now()
 }
 
-// randid generates a random nodeid in range [min, max]
+// randID generates a random nodeid in range [min, max]
 func (b *Bucket) randID() []byte {
 	// var d *big.Int
 	d := big.NewInt(0)
@@ -68,11 +83,32 @@ func (b *Bucket) randID() []byte {
 	return ret
 }
 
+// subnet returns the /24 prefix of an IPv4 address or the /64 prefix
+// of an IPv6 address, it returns an empty string if ip is not set.
+func subnet(ip net.IP) string {
+	if ip == nil || ip.IsUnspecified() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
+
 // RoutingTable maintains active neighbours in DHT network.
+// It is shared by message broker and updater, mu guards all fields.
 type RoutingTable struct {
+	mu sync.Mutex
+
 	id            Identifier
 	buckets       []*Bucket
 	numOfContacts int // number of contacts in routing table
+
+	// subnets counts contacts per subnet across all buckets, so that a
+	// single host can't fill the table with made up ids.
+	subnets           map[string]int
+	subnetBucketLimit int
+	subnetTableLimit  int
 }
 
 // NewRoutingTable returns a new routing table with given id.
@@ -83,25 +119,20 @@ func NewRoutingTable(id Identifier) *RoutingTable {
 	buckets[0] = bucket
 
 	table := &RoutingTable{
-		id:            id,
-		buckets:       buckets,
-		numOfContacts: 0,
+		id:                id,
+		buckets:           buckets,
+		numOfContacts:     0,
+		subnets:           make(map[string]int),
+		subnetBucketLimit: config.SubnetBucketLimit,
+		subnetTableLimit:  config.SubnetTableLimit,
 	}
 
-	// if we have this persisted
-	// data, err := getDBSession().loadNodeInfo(id)
-	// if err == nil && len(data) > 0 {
-	// 	err = table.loadRouting(bytes.NewBuffer(data))
-	// 	if err != nil {
-	// 		log.Fatal(err)
-	// 	}
-	// }
 	return table
 }
 
 func (table *RoutingTable) print() {
 	log.Printf("#routing table [id = %s] has %d buckets, %d nodes",
-		table.id.hexString(), len(table.buckets), table.numOfContacts)
+		table.id.HexString(), len(table.buckets), table.numOfContacts)
 
 	for i, b := range table.buckets {
 		log.Printf("##bucket%d, min=%v, max=%v, lastUpdated %v", i, b.min, b.max, b.lastUpdated)
@@ -111,76 +142,117 @@ func (table *RoutingTable) print() {
 	}
 }
 
-// LoadRouting reads data from reader, attempts to decode data into node contacts and
-// inserts them into current routing table.
-// TODO: get rid of magic number, return bytes read and error, return is very ugly.
-func (table *RoutingTable) loadRouting(reader io.Reader) error {
-	buf := bufio.NewReader(reader)
-	data := make([]byte, 24)
-	_, err := buf.Read(data)
-	if err != nil {
-		return err
-	}
+// contacts returns all contacts in routing table.
+func (table *RoutingTable) contacts() []*Contact {
+	table.mu.Lock()
+	defer table.mu.Unlock()
 
-	var length uint32
-	err = binary.Read(bytes.NewBuffer(data[20:24]), binary.LittleEndian, &length)
-	if err != nil {
-		return err
-	}
-
-	stream := make([]byte, length)
-	_, err = buf.Read(stream)
-	if err != nil {
-		return err
-	}
-	nodes := decodeContacts(stream)
-	log.Printf("%d nodes loaded from database", len(nodes))
-	for _, node := range nodes {
-		table.insertNode(node)
+	var contacts []*Contact
+	for _, b := range table.buckets {
+		contacts = append(contacts, b.nodes...)
 	}
-
-	// TODO: get rid of these shitty returns
-	return nil
+	return contacts
 }
 
-// persist encodes current nodes in routing table and saves to underlying datastore.
-func (table *RoutingTable) persist() {
-	log.Printf("saving routing table information to database")
-	table.print()
+// find returns the contact with given id, nil if there's none.
+func (table *RoutingTable) find(id Identifier) *Contact {
+	table.mu.Lock()
+	defer table.mu.Unlock()
 
-	data := bytes.NewBuffer(nil)
-	for _, b := range table.buckets {
-		for _, n := range b.nodes {
-			encodeContact(data, n)
+	b, _ := table.findBucket(id)
+	for _, n := range b.nodes {
+		if bytes.Equal(n.id, id) {
+			return n
 		}
 	}
+	return nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	buf.Write(table.id)
-	binary.Write(buf, binary.LittleEndian, uint32(data.Len()))
-	buf.Write(data.Bytes())
-	getDBSession().updateNodeInfo(table.id, buf.Bytes())
+// size returns the number of contacts in routing table.
+func (table *RoutingTable) size() int {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.numOfContacts
 }
 
 // insertNode first looks for a bucket index and tries to insert
 // if bucket is already full, node is either dropped or bucket is
-// split into two buckets each with half of the node ID space
+// split into two buckets each with half of the node ID space, a full
+// bucket which can't split makes room by evicting a bad contact.
+// Nodes from a subnet which already reached its limit are dropped,
+// existing contacts are always preferred over new ones.
 func (table *RoutingTable) insertNode(node *Contact) {
-	log.Printf("inserting %s to routing table %s", node, table.id.hexString())
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.insert(node)
+}
+
+func (table *RoutingTable) insert(node *Contact) {
+	log.Printf("inserting %s to routing table %s", node, table.id.HexString())
 	b, idx := table.findBucket(node.id)
 	if idx < maxNumOfBuckets {
 		if b.contains(node) {
 			b.lastUpdated = time.Now()
+		} else if !table.admits(b, node) {
+			log.Printf("dropping %s, too many contacts from subnet %s", node, subnet(node.ip))
 		} else if len(b.nodes) < maxNodesPerBucket {
 			b.insert(node)
 			table.numOfContacts++
+			if s := subnet(node.ip); s != "" {
+				table.subnets[s]++
+			}
 		} else if idx == len(table.buckets)-1 {
 			table.splitBucket(b)
-			table.insertNode(node)
+			table.insert(node)
+		} else if i := b.bad(); i >= 0 {
+			table.evict(b, i)
+			table.insert(node)
+		}
+	}
+}
+
+// bad returns the index of a bad contact in bucket, or -1 if there is none.
+func (b *Bucket) bad() int {
+	for i, n := range b.nodes {
+		n.mu.Lock()
+		status := n.status
+		n.mu.Unlock()
+		if status == Bad {
+			return i
+		}
+	}
+	return -1
+}
+
+// evict removes the i-th contact of bucket b from routing table.
+func (table *RoutingTable) evict(b *Bucket, i int) {
+	n := b.nodes[i]
+	log.Printf("evicting %s from routing table %s", n, table.id.HexString())
+	b.nodes = append(b.nodes[:i], b.nodes[i+1:]...)
+	table.numOfContacts--
+	if s := subnet(n.ip); s != "" {
+		if table.subnets[s]--; table.subnets[s] <= 0 {
+			delete(table.subnets, s)
 		}
 	}
 }
 
+// admits checks if node's subnet is still below its limits in
+// bucket b and in the whole table.
+func (table *RoutingTable) admits(b *Bucket, node *Contact) bool {
+	s := subnet(node.ip)
+	if s == "" {
+		return true
+	}
+	if table.subnetTableLimit > 0 && table.subnets[s] >= table.subnetTableLimit {
+		return false
+	}
+	if table.subnetBucketLimit > 0 && b.countSubnet(s) >= table.subnetBucketLimit {
+		return false
+	}
+	return true
+}
+
 func (table *RoutingTable) splitBucket(b *Bucket) {
 	mid := util.Mid(b.min, b.max)
 	var newBucket *Bucket
@@ -237,8 +309,10 @@ func (table *RoutingTable) bucketIdx(id Identifier) int {
 
 // FindClosest returns SearchResultNum
 func (table *RoutingTable) findLocalClosest(id Identifier) []*Contact {
-	log.Printf("searching local routing table for node %s", id.hexString())
-	// log.Printf("routing table id %s", table.id.hexString())
+	log.Printf("searching local routing table for node %s", id.HexString())
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	// log.Printf("routing table id %s", table.id.HexString())
 	// table.print()
 
 	var result []*Contact
@@ -258,14 +332,14 @@ func (table *RoutingTable) findLocalNode(p, offset int, result *[]*Contact) {
 	// log.Printf("currently %d search results, search continues", len(*result))
 	if p-offset >= 0 {
 		for _, n := range table.buckets[p-offset].nodes {
-			if n.status == Good && len(*result) < maxNumOfSearchResults {
+			if n.isGood() && len(*result) < maxNumOfSearchResults {
 				*result = append(*result, n)
 			}
 		}
 	}
 	if p+offset < len(table.buckets) && offset > 0 {
 		for _, n := range table.buckets[p+offset].nodes {
-			if n.status == Good && len(*result) < maxNumOfSearchResults {
+			if n.isGood() && len(*result) < maxNumOfSearchResults {
 				*result = append(*result, n)
 			}
 		}
@@ -0,0 +1,200 @@
+package dht
+
+import (
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/rliu054/magnetsearch/util"
+)
+
+var testids = []string{
+	"0000000000000000000000000000000000000001",
+	"0000000000000000000000000000000000000010",
+	"0000000000000000000000000000000000000100",
+	"0000000000000000000000000000000000001000",
+	"0000000000000000000000000000000000010000",
+	"0000000000000000000000000000000000100000",
+	"0000000000000000000000000000000001000000",
+	"0000000000000000000000000000000010000000",
+
+	"0000000000000000000000000000000011111111",
+
+	"0000000000000000000000000000000100000000",
+	"0000000000000000000000000000001000000000",
+	"0000000000000000000000000000010000000000",
+	"0000000000000000000000000000100000000000",
+	"0000000000000000000000000001000000000000",
+	"0000000000000000000000000010000000000000",
+	"0000000000000000000000000100000000000000",
+	"0000000000000000000000001000000000000000",
+}
+
+func TestSearchNode(t *testing.T) {
+	id := RandomID()
+	table := NewRoutingTable(id)
+	c := NewContact(id)
+	table.insertNode(c)
+
+	results := table.findLocalClosest(id)
+	if results[0].id.HexString() != id.HexString() {
+		t.Errorf("expected search result: %s, got:  %s",
+			id.HexString(), results[0].id.HexString())
+	}
+}
+
+func TestSearchNodeMulti(t *testing.T) {
+	id := HexToID("0000000000000000000000000000000011111111")
+	table := NewRoutingTable(id)
+
+	for _, v := range testids {
+		c := NewContact(HexToID(v))
+		table.insertNode(c)
+	}
+
+	table.print()
+
+	results := table.findLocalClosest(id)
+
+	for i := range results {
+		log.Printf("%s", results[i])
+	}
+
+	if len(results) < maxNumOfSearchResults {
+		t.Errorf("expected %d nodes returned, got: %d nodes",
+			maxNumOfSearchResults, len(results))
+	}
+}
+
+func TestSearchNodeInBucket(t *testing.T) {
+	bucket := NewBucket(big.NewInt(0), big.NewInt(0).Lsh(util.Binew(1), 160))
+	c := NewContact(RandomID())
+
+	if bucket.contains(c) == true {
+		t.Errorf("expected if bucket contains node %v, got:  %v",
+			false, bucket.contains(c))
+	}
+
+	bucket.insert(c)
+	if bucket.contains(c) == false {
+		t.Errorf("expected if bucket contains node %v, got:  %v",
+			true, bucket.contains(c))
+	}
+}
+
+func TestSplitBucket(t *testing.T) {
+	table := NewRoutingTable(RandomID())
+	for i := 0; i < 2*maxNodesPerBucket; i++ {
+		c := NewContact(RandomID())
+		table.insertNode(c)
+	}
+
+	if table.numOfContacts != 2*maxNodesPerBucket {
+		t.Errorf("expected total %d nodes, got: %d nodes",
+			2*maxNodesPerBucket, table.numOfContacts)
+	}
+}
+
+func TestSubnetBucketLimit(t *testing.T) {
+	table := NewRoutingTable(RandomID())
+	for i := 0; i < maxNodesPerBucket; i++ {
+		c := NewContact(RandomID())
+		c.ip = net.IPv4(10, 0, 0, byte(i+1))
+		c.port = 6881
+		table.insertNode(c)
+	}
+
+	if table.numOfContacts != config.SubnetBucketLimit {
+		t.Errorf("expected %d nodes from one subnet, got: %d nodes",
+			config.SubnetBucketLimit, table.numOfContacts)
+	}
+}
+
+func TestSubnetTableLimit(t *testing.T) {
+	table := NewRoutingTable(HexToID("0000000000000000000000000000000000000000"))
+	table.subnetBucketLimit = 0
+
+	// spread contacts over many buckets so only the table limit applies
+	for i := 0; i < 2*config.SubnetTableLimit; i++ {
+		id := make(Identifier, 20)
+		id[i/8] = 0x80 >> uint(i%8)
+		c := NewContact(id)
+		c.ip = net.ParseIP("2001:db8::" + strconv.Itoa(i+1))
+		table.insertNode(c)
+	}
+
+	if table.numOfContacts != config.SubnetTableLimit {
+		t.Errorf("expected %d nodes from one subnet, got: %d nodes",
+			config.SubnetTableLimit, table.numOfContacts)
+	}
+}
+
+func TestSubnetKeepsExisting(t *testing.T) {
+	table := NewRoutingTable(RandomID())
+	c := NewContact(RandomID())
+	c.ip = net.IPv4(10, 0, 0, 1)
+	table.insertNode(c)
+
+	fake := NewContact(c.id)
+	fake.ip = net.IPv4(192, 168, 0, 1)
+	table.insertNode(fake)
+
+	results := table.findLocalClosest(c.id)
+	if !results[0].ip.Equal(c.ip) {
+		t.Errorf("expected contact ip: %s, got: %s", c.ip, results[0].ip)
+	}
+}
+
+func TestSubnetEviction(t *testing.T) {
+	table := NewRoutingTable(HexToID("0000000000000000000000000000000000000000"))
+	table.subnetBucketLimit = 0
+	table.subnetTableLimit = 2
+
+	// fill the far half of the id space, the extra contact splits
+	// the table so that the far bucket can't split anymore
+	far := func(ip net.IP) *Contact {
+		id := RandomID()
+		id[0] |= 0x80
+		c := NewContact(id)
+		c.ip, c.port = ip, 6881
+		return c
+	}
+	var contacts []*Contact
+	for i := 0; i < maxNodesPerBucket; i++ {
+		ip := net.IPv4(10, 0, byte(i), 1)
+		if i < 2 {
+			ip = net.IPv4(10, 0, 0, byte(i+1))
+		}
+		contacts = append(contacts, far(ip))
+		table.insertNode(contacts[i])
+	}
+	table.insertNode(far(net.IPv4(10, 0, 8, 1)))
+	if table.numOfContacts != maxNodesPerBucket || len(table.buckets) != 2 {
+		t.Fatalf("expected a full far bucket, got: %d contacts in %d buckets",
+			table.numOfContacts, len(table.buckets))
+	}
+
+	// a bad contact of the full subnet makes room for a contact of
+	// another subnet, after which the subnet admits a contact again
+	bad := func(c *Contact) {
+		for i := 0; i < 3; i++ {
+			c.observeTimeout()
+		}
+	}
+	bad(contacts[0])
+	table.insertNode(far(net.IPv4(10, 0, 9, 1)))
+	if n := table.subnets["10.0.0.0"]; n != 1 {
+		t.Fatalf("expected 1 contact from subnet after eviction, got: %d", n)
+	}
+	bad(contacts[5])
+	readmitted := far(net.IPv4(10, 0, 0, 3))
+	table.insertNode(readmitted)
+	if table.find(readmitted.id) == nil || table.subnets["10.0.0.0"] != 2 {
+		t.Errorf("expected %s to be admitted, got: %d contacts from subnet", readmitted, table.subnets["10.0.0.0"])
+	}
+	if table.numOfContacts != maxNodesPerBucket {
+		t.Errorf("expected %d contacts, got: %d contacts", maxNodesPerBucket, table.numOfContacts)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package dht
 
 import (
 	"bytes"
@@ -36,6 +36,42 @@ type Error struct {
 	// e *list.List
 }
 
+// TxID returns transaction id of m.
+func (m *KRPCMessage) TxID() string {
+	return m.t
+}
+
+// Type returns type of m, "q" for a query, "r" for a response
+// and "e" for an error.
+func (m *KRPCMessage) Type() string {
+	return m.y
+}
+
+// Addr returns the address m was received from.
+func (m *KRPCMessage) Addr() *net.UDPAddr {
+	return m.addr
+}
+
+// Method returns method name of a query, it is empty for other messages.
+func (m *KRPCMessage) Method() string {
+	if q, ok := m.ext.(*Query); ok {
+		return q.q
+	}
+	return ""
+}
+
+// Args returns arguments of a query, or named return values of
+// a response. Middleware may modify them in place.
+func (m *KRPCMessage) Args() map[string]interface{} {
+	switch ext := m.ext.(type) {
+	case *Query:
+		return ext.a
+	case *Response:
+		return ext.r
+	}
+	return nil
+}
+
 // NewTxID returns a new transaction id.
 //TODO: other ways of atomic operations
 func (krpc *KRPC) NewTxID() uint32 {
@@ -114,6 +150,33 @@ func (krpc *KRPC) encodePong(nodeID string, txID string) (string, error) {
 	return resp, err
 }
 
+// encodeResponse encodes a response with named return values r,
+// the id of the answering node is added to r.
+func (krpc *KRPC) encodeResponse(txID string, nodeID string, r map[string]interface{}) (string, error) {
+	arg := make(map[string]interface{})
+	for k, v := range r {
+		arg[k] = v
+	}
+	arg["id"] = nodeID
+
+	p := make(map[string]interface{})
+	p["t"] = txID
+	p["y"] = "r"
+	p["r"] = arg
+	return bencode.EncodeString(p)
+}
+
+// encodeError encodes a KRPC error with code and message.
+func (krpc *KRPC) encodeError(txID string, code int, msg string) (string, error) {
+	p := make(map[string]interface{})
+	p["t"] = txID
+	p["y"] = "e"
+	p["e"] = []interface{}{code, msg}
+
+	resp, err := bencode.EncodeString(p)
+	return resp, err
+}
+
 func (krpc *KRPC) encodeGetPeers(nodeID string, infohash Identifier) (uint32, string, error) {
 	txid := krpc.NewTxID()
 	p := make(map[string]interface{})
@@ -128,7 +191,7 @@ func (krpc *KRPC) encodeGetPeers(nodeID string, infohash Identifier) (uint32, st
 	return txid, resp, err
 }
 
-func (krpc *KRPC) encodeAnnouncePeer(nodeID string, infohash Identifier, port int, token string) (uint32, string, error) {
+func (krpc *KRPC) encodeAnnouncePeer(nodeID string, infohash Identifier, port int, impliedPort bool, token string) (uint32, string, error) {
 	txid := krpc.NewTxID()
 	p := make(map[string]interface{})
 	p["t"] = fmt.Sprintf("%d", txid)
@@ -139,6 +202,9 @@ func (krpc *KRPC) encodeAnnouncePeer(nodeID string, infohash Identifier, port in
 	arg["token"] = token
 	arg["port"] = port
 	arg["implied_port"] = 0
+	if impliedPort {
+		arg["implied_port"] = 1
+	}
 	arg["info_hash"] = infohash.String()
 	p["a"] = arg
 	resp, err := bencode.EncodeString(p)
@@ -0,0 +1,189 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	expirationInterval = time.Minute * 25
+	// maxNodesPerBucket  = 8
+)
+
+// startUpdater refreshes DHT node's routing table
+// every 10 seconds, it also bootstraps a node
+// by search for its own id
+func (node *Node) startUpdater() {
+	log.Printf("starting updater...")
+
+	if len(node.restored) > 0 {
+		node.verifyContacts(node.restored)
+		node.restored = nil
+	}
+
+	if node.table.size() == 0 {
+		node.searchNodes(node.info.id)
+	} else {
+		// node.refreshTable()
+	}
+
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+	for {
+		// node.table.print()
+		select {
+		case <-ticker.C:
+			node.refreshTable()
+		case <-node.ctx.Done():
+			return
+		}
+	}
+}
+
+func (node *Node) refreshTable() {
+	log.Printf("refreshing local routing table")
+	node.searchNodes(RandomID())
+
+	// for _, b := range node.table.buckets {
+	// 	//TODO: fix check if expired
+	// 	if b.lastUpdated.Add(expirationInterval).Before(time.Now()) || len(b.nodes) == 0 {
+	// 		log.Printf("%v", b.lastUpdated.Add(expirationInterval).Before(time.Now()))
+	// 		log.Printf("%d", len(b.nodes))
+	// 		id := b.randID()
+	// 		node.searchNodes(id)
+	// 		// node.table.print()
+	// 		node.persist()
+	// 	}
+	// }
+}
+
+// searchNodes looks up target in the network and adds the nodes
+// which answered to routing table.
+func (node *Node) searchNodes(target Identifier) {
+	ctx, cancel := context.WithTimeout(node.ctx, config.LookupTimeout)
+	defer cancel()
+
+	if _, err := node.FindNode(ctx, target); err != nil {
+		log.Printf("error occurred while searching for node %s: %v", target.HexString(), err)
+	}
+}
+
+// FindNode runs an iterative find_node lookup for target.
+func (node *Node) FindNode(ctx context.Context, target Identifier) (*LookupResult, error) {
+	log.Printf("searching for node %s in network", target.HexString())
+	return node.runLookup(ctx, newLookup(node, target, "find_node"))
+}
+
+// GetPeers runs an iterative get_peers lookup for infohash. Peers are saved
+// to the peer store as they are found, the result holds all peers and the
+// announce tokens of the closest nodes. Results are cached for a while
+// and concurrent calls for one infohash share a single lookup.
+func (node *Node) GetPeers(ctx context.Context, infohash Identifier) (*LookupResult, error) {
+	return node.peerResults.get(ctx, infohash.HexString(), func(ctx context.Context) (*LookupResult, error) {
+		return node.runLookup(ctx, node.newGetPeersLookup(infohash))
+	})
+}
+
+// newGetPeersLookup returns a get_peers lookup which saves peers it finds.
+func (node *Node) newGetPeersLookup(infohash Identifier) *lookup {
+	log.Printf("searching for peers of %s in network", infohash.HexString())
+	l := newLookup(node, infohash, "get_peers")
+	l.on(func(e LookupEvent) {
+		if e.Kind != PeerFound {
+			return
+		}
+		node.addPeer(infohash, e.Peer)
+	})
+	return l
+}
+
+// runLookup runs l from the closest nodes in routing table, or from
+// bootstrap nodes if routing table is empty. Nodes which answered are
+// added to routing table before LookupDone is emitted.
+func (node *Node) runLookup(ctx context.Context, l *lookup) (*LookupResult, error) {
+	var startNodes, boot []*Contact
+	if node.table.size() == 0 {
+		log.Printf("routing table is empty, bootstrapping")
+		boot = node.boot.contacts(ctx)
+		if len(boot) == 0 {
+			err := fmt.Errorf("no bootstrap nodes available")
+			l.finish(nil, err)
+			l.emit(LookupEvent{Kind: LookupDone, Err: err})
+			return nil, err
+		}
+		startNodes = boot
+	} else {
+		log.Printf("searching for starter nodes from local routing table")
+		startNodes = node.table.findLocalClosest(l.target)
+	}
+
+	result, err := l.run(ctx, startNodes)
+	log.Printf("%s lookup for %s done in %v: %d hops, %d queries, %d timeouts, %d peers",
+		l.method, l.target.HexString(), result.Duration, result.Hops, result.Queries, result.Timeouts, len(result.Peers))
+
+	for _, c := range l.seen {
+		if c.state == candidateResponded {
+			node.table.insertNode(c.contact)
+		}
+	}
+
+	if len(boot) > 0 {
+		answered := 0
+		for _, c := range boot {
+			if queried, ok := l.answered(c); queried {
+				node.boot.record(c, ok)
+				if ok {
+					answered++
+				}
+			}
+		}
+		log.Printf("bootstrap: %d of %d bootstrap nodes answered, routing table has %d contacts",
+			answered, len(boot), node.table.size())
+	}
+	l.finish(result, err)
+	l.emit(LookupEvent{Kind: LookupDone, Result: result, Err: err})
+	return result, err
+}
+
+// known replaces contacts already in routing table with the table's copy,
+// so that their round trip times are taken into account.
+func (node *Node) known(contacts []*Contact) []*Contact {
+	for i, c := range contacts {
+		if k := node.table.find(c.id); k != nil && k.ip.Equal(c.ip) && k.port == c.port {
+			contacts[i] = k
+		}
+	}
+	return contacts
+}
+
+// Ping sends a ping to addr and returns the id it answers with,
+// a node which answers is added to routing table.
+func (node *Node) Ping(ctx context.Context, addr *net.UDPAddr) (Identifier, error) {
+	r := node.sendPing(&Contact{id: RandomID(), ip: addr.IP, port: addr.Port, lastSeen: time.Now()})
+	if r == nil {
+		return nil, fmt.Errorf("error occurred while sending ping to %s", addr)
+	}
+	req := waitResponse(ctx, r)
+	if req == nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ping to %s timed out", addr)
+	}
+	resp, ok := req.resp.ext.(*Response)
+	if !ok {
+		return nil, fmt.Errorf("%s answered ping with an error", addr)
+	}
+	id, ok := resp.r["id"].(string)
+	if !ok || len(id) != 20 {
+		return nil, fmt.Errorf("%s answered ping with an invalid id", addr)
+	}
+
+	c := &Contact{id: Identifier(id), ip: addr.IP, port: addr.Port, status: Good, lastSeen: time.Now()}
+	c.inherit(r.info)
+	node.table.insertNode(c)
+	return c.id, nil
+}
@@ -0,0 +1,236 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"time"
+)
+
+// Snapshots are stored as
+//
+//	magic(4) | version(1) | id(20) | secret length(2) | secret |
+//	previous secret length(2) | previous secret |
+//	contact count(4) | contacts(26 each) | crc32(4)
+//
+// all integers are big endian, the checksum covers everything before it.
+// Version 1 snapshots have no previous secret.
+const (
+	snapshotMagic   = "MSNS"
+	snapshotVersion = 2
+)
+
+// snapshot is the persisted state of a node which is needed for a
+// warm restart.
+type snapshot struct {
+	id       Identifier
+	secret   []byte
+	previous []byte
+	contacts []*Contact
+}
+
+// encode serializes snapshot into the versioned, checksummed format.
+func (s *snapshot) encode() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(snapshotMagic)
+	buf.WriteByte(snapshotVersion)
+	buf.Write(s.id)
+	binary.Write(buf, binary.BigEndian, uint16(len(s.secret)))
+	buf.Write(s.secret)
+	binary.Write(buf, binary.BigEndian, uint16(len(s.previous)))
+	buf.Write(s.previous)
+	binary.Write(buf, binary.BigEndian, uint32(len(s.contacts)))
+	for _, c := range s.contacts {
+		encodeContact(buf, c)
+	}
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// decodeSnapshot parses data produced by snapshot.encode. Data saved
+// before snapshots were versioned, which has no header, is still accepted.
+func decodeSnapshot(data []byte) (*snapshot, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return decodeLegacySnapshot(data)
+	}
+	if len(data) < len(snapshotMagic)+1+20+2+4+4 {
+		return nil, fmt.Errorf("snapshot is truncated, %d bytes", len(data))
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	r := bytes.NewReader(body[len(snapshotMagic):])
+	version, _ := r.ReadByte()
+	if version < 1 || version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	s := &snapshot{id: make(Identifier, 20)}
+	r.Read(s.id)
+
+	var err error
+	if s.secret, err = readSecret(r); err != nil {
+		return nil, err
+	}
+	if version >= 2 {
+		if s.previous, err = readSecret(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if int(count)*26 != r.Len() {
+		return nil, fmt.Errorf("snapshot has %d bytes for %d contacts", r.Len(), count)
+	}
+	contacts := make([]byte, r.Len())
+	r.Read(contacts)
+	s.contacts = decodeContacts(contacts)
+	return s, nil
+}
+
+// readSecret reads a secret prefixed with its length.
+func readSecret(r *bytes.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, length)
+	if n, _ := r.Read(secret); n != int(length) {
+		return nil, fmt.Errorf("snapshot secret is truncated")
+	}
+	return secret, nil
+}
+
+// decodeLegacySnapshot reads id(20) | length(4, little endian) | contacts.
+func decodeLegacySnapshot(data []byte) (*snapshot, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("snapshot is truncated, %d bytes", len(data))
+	}
+	length := binary.LittleEndian.Uint32(data[20:24])
+	if int(length) > len(data)-24 {
+		return nil, fmt.Errorf("snapshot has %d bytes, expected %d", len(data)-24, length)
+	}
+	return &snapshot{
+		id:       Identifier(data[:20]),
+		contacts: decodeContacts(data[24 : 24+length]),
+	}, nil
+}
+
+// snapshot captures node's id, token secrets and the IPv4 contacts in its
+// routing table.
+func (node *Node) snapshot() *snapshot {
+	s := &snapshot{id: node.info.id}
+	s.secret, s.previous = node.tokens.secrets()
+	for _, c := range node.table.contacts() {
+		if c.ip.To4() != nil {
+			s.contacts = append(s.contacts, c)
+		}
+	}
+	return s
+}
+
+// NodeStore saves node snapshots, a node keeps its id, token secrets
+// and contacts across restarts.
+type NodeStore interface {
+	// LoadNode returns the snapshot saved for id, it is empty
+	// if there is none.
+	LoadNode(id Identifier) ([]byte, error)
+
+	// SaveNode saves snapshot of node id.
+	SaveNode(id Identifier, snapshot []byte) error
+
+	// NodeIDs returns ids of all saved nodes, most recently saved first.
+	NodeIDs() ([]Identifier, error)
+}
+
+// persist saves a snapshot of node to its store.
+func (node *Node) persist() {
+	if node.store == nil {
+		return
+	}
+	s := node.snapshot()
+	log.Printf("saving snapshot of node %s with %d contacts", node.info.id.HexString(), len(s.contacts))
+	if err := node.store.SaveNode(node.info.id, s.encode()); err != nil {
+		log.Printf("error occurred while saving snapshot: %v", err)
+	}
+}
+
+// restore loads the persisted identity of node. Restored contacts are
+// not trusted until they answer a ping, see verifyContacts.
+func (node *Node) restore() {
+	id := node.info.id
+	data, err := node.store.LoadNode(id)
+	if err != nil || len(data) == 0 {
+		log.Printf("no snapshot for node %s, starting fresh", id.HexString())
+		return
+	}
+	s, err := decodeSnapshot(data)
+	if err != nil {
+		log.Printf("error occurred while decoding snapshot of node %s: %v", id.HexString(), err)
+		return
+	}
+	if len(s.secret) > 0 {
+		node.tokens.restore(s.secret, s.previous)
+	}
+	node.restored = s.contacts
+	log.Printf("restored node %s with %d contacts", id.HexString(), len(s.contacts))
+}
+
+// verifyContacts pings contacts and inserts the ones that answer
+// into routing table.
+func (node *Node) verifyContacts(contacts []*Contact) {
+	log.Printf("pinging %d restored contacts", len(contacts))
+
+	var reqs []*Request
+	for _, c := range contacts {
+		if r := node.sendPing(c); r != nil {
+			reqs = append(reqs, r)
+		}
+	}
+
+	answered := 0
+	ch := checkResponses(node.ctx, reqs)
+	for i := 0; i < len(reqs); i++ {
+		req := <-ch
+		if req == nil {
+			continue
+		}
+		if resp, ok := req.resp.ext.(*Response); ok {
+			if id, ok := resp.r["id"].(string); ok && id == req.info.id.String() {
+				req.info.lastSeen = time.Now()
+				node.table.insertNode(req.info)
+				answered++
+			}
+		}
+	}
+	log.Printf("%d of %d restored contacts answered", answered, len(contacts))
+}
+
+// sendPing sends a ping request to contact c.
+func (node *Node) sendPing(c *Contact) *Request {
+	txid, data, err := node.krpc.encodePing(node.info.id.String())
+	if err != nil {
+		log.Printf("error occurred while encoding ping to %s", c)
+		return nil
+	}
+
+	r := NewRequest(c, txid)
+	if !node.track(r) {
+		return nil
+	}
+	addr := &net.UDPAddr{IP: c.ip, Port: c.port}
+	if _, err = node.transport.writeMsgUDP([]byte(data), addr); err != nil {
+		log.Print(err)
+		return nil
+	}
+	return r
+}
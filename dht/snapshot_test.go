@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	s1 := &snapshot{id: RandomID(), secret: []byte("secret")}
+	for i := 0; i < 3; i++ {
+		c := NewContact(RandomID())
+		c.ip = net.IPv4(10, 0, 0, byte(i+1)).To4()
+		c.port = 6881 + i
+		s1.contacts = append(s1.contacts, c)
+	}
+
+	s2, err := decodeSnapshot(s1.encode())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !bytes.Equal(s1.id, s2.id) || !bytes.Equal(s1.secret, s2.secret) {
+		t.Errorf("expected id %s and secret %q, got: %s and %q",
+			s1.id.HexString(), s1.secret, s2.id.HexString(), s2.secret)
+	}
+	if !bytes.Equal(encodeContacts(s1.contacts), encodeContacts(s2.contacts)) {
+		t.Errorf("expected %d contacts restored, got: %v", len(s1.contacts), s2.contacts)
+	}
+}
+
+func TestSnapshotChecksum(t *testing.T) {
+	s := &snapshot{id: RandomID(), secret: []byte("secret")}
+	data := s.encode()
+	data[len(snapshotMagic)+3] ^= 0xFF
+
+	if _, err := decodeSnapshot(data); err == nil {
+		t.Errorf("expected checksum error, got: nil")
+	}
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	s := &snapshot{id: RandomID(), secret: []byte("secret")}
+	data := s.encode()
+
+	// drop the previous secret and turn data into a version 1 snapshot
+	offset := len(snapshotMagic) + 1 + 20 + 2 + len(s.secret)
+	v1 := append(append([]byte(nil), data[:offset]...), data[offset+2:len(data)-4]...)
+	v1[len(snapshotMagic)] = 1
+	v1 = append(v1, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(v1[len(v1)-4:], crc32.ChecksumIEEE(v1[:len(v1)-4]))
+
+	restored, err := decodeSnapshot(v1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !bytes.Equal(restored.secret, s.secret) || len(restored.previous) != 0 {
+		t.Errorf("expected secret %q without previous, got: %q and %q", s.secret, restored.secret, restored.previous)
+	}
+}
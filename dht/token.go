@@ -0,0 +1,83 @@
+package dht
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"net"
+	"sync"
+)
+
+// tokenSecrets generates and validates announce tokens the way BEP 5
+// suggests. A token is HMAC-SHA1(secret, ip) and the secret rotates every
+// TokenRotation, tokens made with the current or the previous secret are
+// accepted. Nothing is stored per token, so memory stays flat.
+type tokenSecrets struct {
+	mu       sync.Mutex
+	current  []byte
+	previous []byte
+}
+
+func newTokenSecrets() *tokenSecrets {
+	return &tokenSecrets{current: newSecret()}
+}
+
+func newSecret() []byte {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		// crypto/rand doesn't fail on supported platforms
+		panic(err)
+	}
+	return secret
+}
+
+// rotate replaces current secret with a new one, tokens made with the
+// replaced secret stay valid until the next rotation.
+func (t *tokenSecrets) rotate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.previous, t.current = t.current, newSecret()
+}
+
+// restore sets secrets loaded from a snapshot.
+func (t *tokenSecrets) restore(current, previous []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current, t.previous = current, previous
+}
+
+// secrets returns current and previous secret.
+func (t *tokenSecrets) secrets() ([]byte, []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current, t.previous
+}
+
+// generate returns the token for ip.
+func (t *tokenSecrets) generate(ip net.IP) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(tokenFor(t.current, ip))
+}
+
+// validate checks if token was generated for ip with current
+// or previous secret.
+func (t *tokenSecrets) validate(token string, ip net.IP) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if hmac.Equal([]byte(token), tokenFor(t.current, ip)) {
+		return true
+	}
+	return len(t.previous) > 0 && hmac.Equal([]byte(token), tokenFor(t.previous, ip))
+}
+
+func tokenFor(secret []byte, ip net.IP) []byte {
+	mac := hmac.New(sha1.New, secret)
+	if ip4 := ip.To4(); ip4 != nil {
+		mac.Write(ip4)
+	} else {
+		mac.Write(ip.To16())
+	}
+	return mac.Sum(nil)
+}
@@ -0,0 +1,29 @@
+package dht
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTokenRotation(t *testing.T) {
+	tokens := newTokenSecrets()
+	ip := net.IPv4(10, 0, 0, 1)
+	token := tokens.generate(ip)
+
+	if !tokens.validate(token, ip) {
+		t.Errorf("expected token to be valid")
+	}
+	if tokens.validate(token, net.IPv4(10, 0, 0, 2)) {
+		t.Errorf("expected token to be invalid for another ip")
+	}
+
+	tokens.rotate()
+	if !tokens.validate(token, ip) {
+		t.Errorf("expected token to be valid after one rotation")
+	}
+
+	tokens.rotate()
+	if tokens.validate(token, ip) {
+		t.Errorf("expected token to be invalid after two rotations")
+	}
+}
@@ -0,0 +1,143 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TraceQuery records a single request of a lookup.
+type TraceQuery struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+
+	// Distance is the log2 XOR distance to target, 0 means the
+	// queried node is the target.
+	Distance int           `json:"distance"`
+	RTT      time.Duration `json:"rtt,omitempty"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+}
+
+// TraceHop holds the queries to nodes learnt at the same hop,
+// hop 0 are the start nodes.
+type TraceHop struct {
+	Hop     int           `json:"hop"`
+	Queries []*TraceQuery `json:"queries"`
+}
+
+// LookupTrace records what a lookup did hop by hop.
+type LookupTrace struct {
+	Target   string        `json:"target"`
+	Method   string        `json:"method"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Hops     []*TraceHop   `json:"hops"`
+
+	// ResultHops, Queries and Timeouts are copied from LookupResult.
+	ResultHops int    `json:"result_hops"`
+	Queries    int    `json:"queries"`
+	Timeouts   int    `json:"timeouts"`
+	Peers      int    `json:"peers"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// record adds a query to the hop it belongs to.
+func (t *LookupTrace) record(hop int, q *TraceQuery) {
+	for len(t.Hops) <= hop {
+		t.Hops = append(t.Hops, &TraceHop{Hop: len(t.Hops)})
+	}
+	t.Hops[hop].Queries = append(t.Hops[hop].Queries, q)
+}
+
+// LookupStats aggregates the traces in a trace ring.
+type LookupStats struct {
+	Lookups        int           `json:"lookups"`
+	SuccessRate    float64       `json:"success_rate"`
+	MedianHops     int           `json:"median_hops"`
+	MedianDuration time.Duration `json:"median_duration"`
+	MeanQueries    float64       `json:"mean_queries"`
+	TimeoutRate    float64       `json:"timeout_rate"`
+}
+
+// traceRing keeps the most recent lookup traces.
+type traceRing struct {
+	mu     sync.Mutex
+	traces []*LookupTrace
+	next   int
+	full   bool
+}
+
+// traces holds traces of all lookups of this process.
+var traces = newTraceRing(config.TraceRingSize)
+
+func newTraceRing(size int) *traceRing {
+	if size < 1 {
+		size = 1
+	}
+	return &traceRing{traces: make([]*LookupTrace, size)}
+}
+
+func (r *traceRing) add(t *LookupTrace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.traces[r.next] = t
+	r.next = (r.next + 1) % len(r.traces)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns at most n traces, most recent first.
+func (r *traceRing) recent(n int) []*LookupTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	size := r.next
+	if r.full {
+		size = len(r.traces)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+	ret := make([]*LookupTrace, 0, n)
+	for i := 1; i <= n; i++ {
+		ret = append(ret, r.traces[(r.next-i+len(r.traces))%len(r.traces)])
+	}
+	return ret
+}
+
+// stats aggregates all traces in ring.
+func (r *traceRing) stats() *LookupStats {
+	ts := r.recent(0)
+	s := &LookupStats{Lookups: len(ts)}
+	if len(ts) == 0 {
+		return s
+	}
+
+	var hops []int
+	var durations []time.Duration
+	successes, queries, timeouts := 0, 0, 0
+	for _, t := range ts {
+		if t.Success {
+			successes++
+			hops = append(hops, t.ResultHops)
+		}
+		durations = append(durations, t.Duration)
+		queries += t.Queries
+		timeouts += t.Timeouts
+	}
+	s.SuccessRate = float64(successes) / float64(len(ts))
+	s.MeanQueries = float64(queries) / float64(len(ts))
+	if queries > 0 {
+		s.TimeoutRate = float64(timeouts) / float64(queries)
+	}
+	if len(hops) > 0 {
+		sort.Ints(hops)
+		s.MedianHops = hops[len(hops)/2]
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	s.MedianDuration = durations[len(durations)/2]
+	return s
+}
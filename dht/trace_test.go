@@ -0,0 +1,31 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTraceRing(t *testing.T) {
+	ring := newTraceRing(3)
+	for i := 1; i <= 5; i++ {
+		ring.add(&LookupTrace{
+			Queries:    i,
+			ResultHops: i,
+			Duration:   time.Duration(i) * time.Second,
+			Success:    i != 5,
+		})
+	}
+
+	recent := ring.recent(0)
+	if len(recent) != 3 || recent[0].Queries != 5 || recent[2].Queries != 3 {
+		t.Fatalf("expected traces 5, 4, 3, got: %d traces", len(recent))
+	}
+
+	stats := ring.stats()
+	if stats.Lookups != 3 || stats.MedianHops != 4 || stats.MedianDuration != 4*time.Second {
+		t.Errorf("expected 3 lookups, median 4 hops in 4s, got: %+v", stats)
+	}
+	if stats.SuccessRate < 0.66 || stats.SuccessRate > 0.67 {
+		t.Errorf("expected success rate 2/3, got: %f", stats.SuccessRate)
+	}
+}
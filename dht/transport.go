@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// UDPTransport is a transport for UDP messages.
+type UDPTransport struct {
+	conn       *net.UDPConn
+	middleware *middleware
+}
+
+// NewTransport returns a new UDP transport listening on addr,
+// a nil addr listens on a random port.
+// This transport is used for all network io.
+func NewTransport(addr *net.UDPAddr) (*UDPTransport, error) {
+	c, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPTransport{
+		conn:       c,
+		middleware: new(middleware),
+	}, nil
+}
+
+// writeMsgUDP sends m to addr after running it through outbound
+// middleware, nothing is sent if middleware drops m.
+func (t *UDPTransport) writeMsgUDP(m []byte, addr *net.UDPAddr) (int, error) {
+	if m = t.middleware.out(m, addr); m == nil {
+		return 0, nil
+	}
+	n, err := t.conn.WriteToUDP(m, addr)
+	if err != nil || n == 0 {
+		log.Printf("Error occurred while sending UDP message, %d bytes have been sent", n)
+	}
+	return n, err
+}
+
+// Request represents a UDP message.
+type Request struct {
+	info    *Contact
+	txid    string
+	resp    *KRPCMessage
+	respC   chan *Request
+	sent    time.Time
+	timeout time.Duration
+}
+
+// NewRequest returns a new Request based on contact info and identifier,
+// its timeout adapts to round trip time of the contact.
+func NewRequest(c *Contact, id uint32) *Request {
+	return &Request{
+		info:    c,
+		txid:    fmt.Sprintf("%d", id),
+		resp:    new(KRPCMessage),
+		respC:   make(chan *Request, 1),
+		sent:    time.Now(),
+		timeout: c.timeout(),
+	}
+}
+
+// CheckResponses takes several requests and checks their response channel
+// for possible responses until each request times out or ctx is done.
+func checkResponses(ctx context.Context, reqs []*Request) chan *Request {
+	ch := make(chan *Request, len(reqs))
+	for _, r := range reqs {
+		go func(r *Request) {
+			ch <- waitResponse(ctx, r)
+		}(r)
+	}
+	return ch
+}
+
+// waitResponse blocks until r is answered, times out or ctx is done, it
+// returns nil unless r was answered. Round trip times and timeouts are
+// recorded on the contact.
+func waitResponse(ctx context.Context, r *Request) *Request {
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-r.respC:
+		r.info.observeRTT(time.Since(r.sent))
+		return resp
+	case <-timer.C:
+		r.info.observeTimeout()
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+}
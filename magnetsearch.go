@@ -1,55 +1,256 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rliu054/magnetsearch/dht"
+	"github.com/rliu054/magnetsearch/sink"
 )
 
-func main() {
-	// session := getDBSession()
-	// nodeids, err := session.loadAllNodeIDs()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+// maxActiveNodes is the max number of active nodes at a given time.
+// Need to bump to higher value when codebase is stable.
+const maxActiveNodes = 1
+
+var (
+	bootstrap  = flag.String("bootstrap", "", "comma separated bootstrap sources: host:port, ip:port, file:path or table")
+	importPath = flag.String("import-state", "", "load bootstrap nodes from a libtorrent or Transmission DHT state `file`")
+	exportPath = flag.String("export-state", "", "dump routing tables to a DHT state `file` on shutdown")
+	exportFmt  = flag.String("state-format", dht.StateTransmission, "format of exported DHT state, libtorrent or transmission")
+	paths      = flag.Int("disjoint-paths", 1, "number of disjoint paths of each lookup, more paths resist Sybil attacks")
+	announce   = flag.String("announce", "", "comma separated infohashes to announce as `hex[:port]`, implied port if port is omitted")
+	peerStore  = flag.String("peer-store", "sqlite", "where peers are kept, memory or sqlite")
+	maxPeers   = flag.Int("max-peers", 100, "max number of peers kept per infohash")
+	subnetBkt  = flag.Int("subnet-bucket-limit", 2, "max number of contacts from one /24 or /64 subnet in a routing table bucket, 0 for no limit")
+	subnetTbl  = flag.Int("subnet-table-limit", 10, "max number of contacts from one /24 or /64 subnet in a routing table, 0 for no limit")
+	peerTTL    = flag.Duration("peer-ttl", 30*time.Minute, "how long peers are kept after they were last seen")
+	sinks      = flag.String("sink", "", "comma separated sinks of discovered infohashes: stdout, file:path or webhook:url")
+	rotateSize = flag.Int64("sink-rotate-size", sink.DefaultConfig().FileMaxBytes, "rotate file sinks at this many bytes, 0 disables")
+	rotateAge  = flag.Duration("sink-rotate-age", sink.DefaultConfig().FileMaxAge, "rotate file sinks at this age, 0 disables")
+	batchSize  = flag.Int("webhook-batch", sink.DefaultConfig().WebhookBatch, "max number of infohashes per webhook request")
+	batchWait  = flag.Duration("webhook-interval", sink.DefaultConfig().WebhookInterval, "max time an infohash waits for a webhook batch")
+	spread     = flag.Int("crawler-spread", 0, "crawl with this many identities spread over the id space instead of the saved nodes")
+	maxIDs     = flag.Int("max-identities", 64, "max number of identities a crawler runs, including identities next to infohashes")
+	identTTL   = flag.Duration("identity-ttl", 15*time.Minute, "how long a crawler identity next to an infohash runs")
+	follow     = flag.Bool("crawler-follow", false, "place crawler identities next to infohashes other nodes ask for")
+	targetWait = flag.Duration("target-interval", time.Second, "min time between starting two crawler identities next to infohashes")
+	router     = flag.String("router", "", "run a bootstrap router listening on `addr` which answers only ping and find_node")
+	maxRouted  = flag.Int("router-contacts", dht.DefaultRouterConfig().MaxContacts, "max number of contacts a router holds")
+)
 
-	var nodeids []string
+func main() {
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	master := make(chan string)
-	// logger := os.Stdout
-	// mlogger, err := os.OpenFile("msg", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0744)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+	sources := dht.Bootstrappers
+	if *bootstrap != "" {
+		sources = strings.Split(*bootstrap, ",")
+	}
+	if *importPath != "" {
+		sources = append([]string{dht.SourceFile + *importPath}, sources...)
+	}
 
+	session := getDBSession()
+	nodeids, err := session.NodeIDs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(nodeids) > maxActiveNodes {
+		nodeids = nodeids[:maxActiveNodes]
+	}
 	if len(nodeids) > 0 {
-		//TODO: there's a bug here
-		log.Printf("reloading peers from database")
-		for _, nodeid := range nodeids {
-			go func(id string) {
-				n := NewNode(hexToID(id), master)
-				n.start()
-			}(nodeid)
+		log.Printf("reloading nodes from database")
+	}
+	for len(nodeids) < maxActiveNodes {
+		nodeids = append(nodeids, dht.RandomID())
+	}
+
+	var peers dht.PeerStore
+	switch *peerStore {
+	case "memory":
+		peers = dht.NewMemoryPeerStore(*maxPeers, *peerTTL)
+	case "sqlite":
+		if peers, err = dht.NewSQLitePeerStore(session.db, *maxPeers, *peerTTL); err != nil {
+			log.Fatal(err)
 		}
+	default:
+		log.Fatalf("unknown peer store %q", *peerStore)
 	}
 
-	for i := 0; i < maxActiveNodes-len(nodeids); i++ {
-		go func() {
-			node := NewNode(randID(), master)
-			node.start()
-		}()
+	var out sink.Multi
+	if *sinks != "" {
+		cfg := sink.DefaultConfig()
+		cfg.FileMaxBytes, cfg.FileMaxAge = *rotateSize, *rotateAge
+		cfg.WebhookBatch, cfg.WebhookInterval = *batchSize, *batchWait
+		for _, spec := range strings.Split(*sinks, ",") {
+			s, err := sink.Open(spec, cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			out = append(out, s)
+		}
 	}
 
-	// for {
-	// 	select {
-	// 	case msg := <-master:
-	// 		fmt.Println(msg)
-	// 	}
-	// }
-	port := os.Getenv("PORT")
-	if port != "" {
-		http.ListenAndServe(":"+port, nil)
+	opts := []dht.Option{
+		dht.WithBootstrap(sources...),
+		dht.WithDisjointPaths(*paths),
+		dht.WithSubnetLimits(*subnetBkt, *subnetTbl),
+		dht.WithPeerStore(peers),
+		dht.WithInbound(dht.LogInbound),
+		dht.WithOutbound(dht.LogOutbound),
+		dht.WithInfohashHook(func(e dht.InfohashSeen) {
+			if err := session.addResource(e.Infohash.HexString()); err != nil {
+				log.Printf("error occurred while saving infohash %s: %v", e.Infohash.HexString(), err)
+			}
+			if err := out.Write(sink.FromEvent(e)); err != nil {
+				log.Printf("error occurred while writing infohash %s to sinks: %v", e.Infohash.HexString(), err)
+			}
+		}),
+	}
+
+	var nodes []*dht.Node
+	var crawler *dht.Crawler
+	if *router != "" {
+		addr, err := net.ResolveUDPAddr("udp", *router)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg := dht.DefaultRouterConfig()
+		cfg.MaxContacts = *maxRouted
+		r, err := dht.NewRouter(cfg,
+			dht.WithListenAddr(addr),
+			dht.WithBootstrap(sources...),
+			dht.WithSubnetLimits(*subnetBkt, *subnetTbl),
+			dht.WithInbound(dht.LogInbound),
+			dht.WithOutbound(dht.LogOutbound),
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Start(ctx)
+		nodes = append(nodes, r.Node())
+		http.HandleFunc("/debug/router", dht.RouterHandler(r))
+	} else if *spread > 0 {
+		crawler = dht.NewCrawler(dht.CrawlerConfig{
+			Spread:           *spread,
+			MaxIdentities:    *maxIDs,
+			TargetTTL:        *identTTL,
+			TargetInterval:   *targetWait,
+			FollowInfohashes: *follow,
+		}, opts...)
+		if err := crawler.Start(ctx); err != nil {
+			log.Fatal(err)
+		}
+		nodes = crawler.Nodes()
+		http.HandleFunc("/debug/crawler", dht.IdentitiesHandler(crawler))
 	} else {
-		http.ListenAndServe(":8080", nil)
+		for _, id := range nodeids {
+			node, err := dht.NewNode(append(opts, dht.WithID(id), dht.WithNodeStore(session))...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			node.Start(ctx)
+			nodes = append(nodes, node)
+		}
+	}
+
+	if *announce != "" {
+		for _, s := range strings.Split(*announce, ",") {
+			ih, port, err := parseAnnounce(s)
+			if err != nil {
+				log.Fatalf("error occurred while parsing announce %q: %v", s, err)
+			}
+			nodes[0].Announce(ih, port, port == 0)
+		}
+	}
+
+	http.HandleFunc("/lookup", dht.LookupHandler(nodes[0]))
+	http.HandleFunc("/status", dht.StatusHandler)
+	http.HandleFunc("/debug/lookups", dht.TracesHandler)
+	http.HandleFunc("/debug/lookups/stats", dht.TraceStatsHandler)
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Print(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error occurred while shutting down HTTP server: %v", err)
+	}
+
+	// stopping a node saves a last snapshot of it, identities of a
+	// crawler stop with the crawler
+	var contacts []*dht.Contact
+	if crawler != nil {
+		nodes = crawler.Nodes()
+		crawler.Stop()
+	}
+	for _, node := range nodes {
+		node.Stop()
+		contacts = append(contacts, node.Contacts()...)
+	}
+	if *exportPath != "" && len(nodes) == 0 {
+		log.Printf("no nodes were running, not exporting %s", *exportPath)
+	} else if *exportPath != "" {
+		if err := writeState(*exportPath, *exportFmt, nodes[0].ID(), contacts); err != nil {
+			log.Printf("error occurred while exporting %s: %v", *exportPath, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		log.Printf("error occurred while closing sinks: %v", err)
+	}
+	if err := session.db.Close(); err != nil {
+		log.Printf("error occurred while closing database: %v", err)
+	}
+}
+
+// parseAnnounce parses hex[:port] into an infohash and a port,
+// port is 0 if it is omitted.
+func parseAnnounce(s string) (dht.Identifier, int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	ih := dht.HexToID(parts[0])
+	if ih == nil {
+		return nil, 0, fmt.Errorf("infohash must be 40 hex characters")
+	}
+	if len(parts) == 1 {
+		return ih, 0, nil
+	}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, 0, fmt.Errorf("invalid port %q", parts[1])
+	}
+	return ih, port, nil
+}
+
+// writeState exports contacts to a DHT state file at path.
+func writeState(path, format string, id dht.Identifier, contacts []*dht.Contact) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = dht.ExportState(f, format, id, contacts); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
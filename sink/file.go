@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// File writes records as NDJSON to a file, each record is flushed as it
+// is written. Once the file reaches its max
+// size or age it is renamed to path.<timestamp> and a new file is started.
+type File struct {
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+	created time.Time
+}
+
+// NewFile returns a sink appending to the file at path.
+func NewFile(path string, maxBytes int64, maxAge time.Duration) (*File, error) {
+	s := &File{path: path, maxBytes: maxBytes, maxAge: maxAge}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *File) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.w = f, bufio.NewWriter(f)
+	s.size, s.created = info.Size(), time.Now()
+	return nil
+}
+
+func (s *File) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size > 0 && ((s.maxBytes > 0 && s.size+int64(len(data)) > s.maxBytes) ||
+		(s.maxAge > 0 && time.Since(s.created) >= s.maxAge)) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.w.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	// readers of the feed see every record right away and a crash
+	// loses nothing written before it
+	return s.w.Flush()
+}
+
+// rotate closes the current file, renames it and opens a new one.
+func (s *File) rotate() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	rotated := s.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *File) close() error {
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// Flush writes buffered records to the file.
+func (s *File) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
@@ -0,0 +1,137 @@
+// Package sink writes the feed of infohashes discovered by DHT nodes to
+// files, stdout or webhooks.
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rliu054/magnetsearch/dht"
+)
+
+// Record is a discovered infohash as written by sinks.
+type Record struct {
+	Infohash string    `json:"infohash"`
+	Method   string    `json:"method"`
+	SourceID string    `json:"source_id"`
+	Source   string    `json:"source"`
+	Port     int       `json:"port,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// FromEvent returns the record of e.
+func FromEvent(e dht.InfohashSeen) *Record {
+	return &Record{
+		Infohash: e.Infohash.HexString(),
+		Method:   e.Method,
+		SourceID: e.Source.ID().HexString(),
+		Source:   e.Source.Addr().String(),
+		Port:     e.Port,
+		Time:     e.Time,
+	}
+}
+
+// A Sink receives discovered infohashes.
+type Sink interface {
+	// Write hands r to the sink, sinks may buffer records.
+	Write(r *Record) error
+
+	// Close flushes buffered records and releases the sink.
+	Close() error
+}
+
+// Config holds settings of the built-in sinks.
+type Config struct {
+	// FileMaxBytes and FileMaxAge trigger rotation of a file sink,
+	// zero disables either trigger.
+	FileMaxBytes int64
+	FileMaxAge   time.Duration
+
+	// WebhookBatch is the max number of records in one POST,
+	// WebhookInterval is the longest a record waits for a batch to fill.
+	// A failed POST is retried WebhookRetries times with exponential
+	// backoff starting at WebhookBackoff.
+	WebhookBatch    int
+	WebhookInterval time.Duration
+	WebhookRetries  int
+	WebhookBackoff  time.Duration
+}
+
+// DefaultConfig returns the default settings of the built-in sinks.
+func DefaultConfig() Config {
+	return Config{
+		FileMaxBytes:    100 << 20,
+		FileMaxAge:      24 * time.Hour,
+		WebhookBatch:    100,
+		WebhookInterval: 5 * time.Second,
+		WebhookRetries:  3,
+		WebhookBackoff:  time.Second,
+	}
+}
+
+// Open returns the sink described by spec, one of
+//
+//	stdout          NDJSON on standard output
+//	file:path       NDJSON file at path, rotated per cfg
+//	webhook:url     batches POSTed to url as JSON arrays
+func Open(spec string, cfg Config) (Sink, error) {
+	switch {
+	case spec == "stdout":
+		return NewWriter(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFile(strings.TrimPrefix(spec, "file:"), cfg.FileMaxBytes, cfg.FileMaxAge)
+	case strings.HasPrefix(spec, "webhook:"):
+		return NewWebhook(strings.TrimPrefix(spec, "webhook:"), cfg), nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", spec)
+}
+
+// Writer writes records as NDJSON to an io.Writer.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriter returns a sink writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+func (w *Writer) Write(r *Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(r)
+}
+
+// Close does nothing, the underlying writer is left open.
+func (w *Writer) Close() error {
+	return nil
+}
+
+// Multi writes every record to all of its sinks.
+type Multi []Sink
+
+func (m Multi) Write(r *Record) error {
+	var first error
+	for _, s := range m {
+		if err := s.Write(r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m Multi) Close() error {
+	var first error
+	for _, s := range m {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
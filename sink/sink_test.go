@@ -0,0 +1,117 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rliu054/magnetsearch/dht"
+)
+
+func testRecord() *Record {
+	return &Record{Infohash: dht.RandomID().HexString(), Method: "get_peers", Source: "127.0.0.1:6881", Time: time.Now().Truncate(time.Second)}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriter(&buf)
+	r := testRecord()
+	s.Write(r)
+	s.Write(testRecord())
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got: %q", buf.String())
+	}
+	var got Record
+	if err := json.Unmarshal(lines[0], &got); err != nil || got.Infohash != r.Infohash {
+		t.Errorf("expected record %+v, got: %+v, %v", r, got, err)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infohashes.ndjson")
+	line, _ := json.Marshal(testRecord())
+	s, err := NewFile(path, int64(3*(len(line)+1)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := s.Write(testRecord()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countLines(t, path); n != 2 {
+		t.Errorf("expected records to be flushed before Close, got: %d records", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated file, got: %v", rotated)
+	}
+	if n := countLines(t, rotated[0]); n != 3 {
+		t.Errorf("expected 3 records in rotated file, got: %d", n)
+	}
+	if n := countLines(t, path); n != 2 {
+		t.Errorf("expected 2 records in current file, got: %d", n)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); n++ {
+	}
+	return n
+}
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]Record
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var batch []Record
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.WebhookBatch, cfg.WebhookInterval, cfg.WebhookBackoff = 2, time.Hour, time.Millisecond
+	s := NewWebhook(server.URL, cfg)
+	for i := 0; i < 5; i++ {
+		s.Write(testRecord())
+	}
+	s.Close()
+	s.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 4 {
+		t.Errorf("expected 3 batches and 1 retry, got: %d requests", requests)
+	}
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[2]) != 1 {
+		t.Errorf("expected batches of 2, 2 and 1 records, got: %v", batches)
+	}
+}
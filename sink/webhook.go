@@ -0,0 +1,156 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook POSTs records to a URL in batches, as a JSON array per request.
+// A batch is sent when it is full or has waited for the batch interval,
+// failed requests are retried with exponential backoff and the batch is
+// dropped once all retries failed.
+type Webhook struct {
+	url    string
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []*Record
+	closed  bool
+	batches chan []*Record
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	closeOnce sync.Once
+}
+
+// NewWebhook returns a sink posting to url.
+func NewWebhook(url string, cfg Config) *Webhook {
+	if cfg.WebhookBatch < 1 {
+		cfg.WebhookBatch = 1
+	}
+	s := &Webhook{
+		url:     url,
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		batches: make(chan []*Record, 16),
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.sender()
+	go s.ticker()
+	return s
+}
+
+// Write adds r to the pending batch, the batch is handed to the sender
+// when it is full. If the sender falls behind the batch is dropped.
+func (s *Webhook) Write(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("webhook %s is closed", s.url)
+	}
+	s.pending = append(s.pending, r)
+	if len(s.pending) >= s.cfg.WebhookBatch {
+		return s.flush(false)
+	}
+	return nil
+}
+
+// flush hands pending records to the sender, s.mu must be held.
+func (s *Webhook) flush(wait bool) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	if wait {
+		s.batches <- batch
+		return nil
+	}
+	select {
+	case s.batches <- batch:
+		return nil
+	default:
+		return fmt.Errorf("webhook %s is falling behind, dropped %d records", s.url, len(batch))
+	}
+}
+
+// ticker flushes the pending batch every batch interval.
+func (s *Webhook) ticker() {
+	defer s.wg.Done()
+	t := time.NewTicker(s.cfg.WebhookInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.mu.Lock()
+			if err := s.flush(false); err != nil {
+				log.Print(err)
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sender posts batches until Close.
+func (s *Webhook) sender() {
+	defer s.wg.Done()
+	for batch := range s.batches {
+		if err := s.post(batch); err != nil {
+			log.Printf("error occurred while posting %d records to %s: %v", len(batch), s.url, err)
+		}
+	}
+}
+
+// post sends batch, retrying on network errors and 5xx responses.
+func (s *Webhook) post(batch []*Record) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	backoff := s.cfg.WebhookBackoff
+	for i := 0; ; i++ {
+		retry, err := s.send(data)
+		if err == nil || !retry || i >= s.cfg.WebhookRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send posts data once, it reports whether a failed post may be retried.
+func (s *Webhook) send(data []byte) (bool, error) {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Close sends the pending batch and waits for all batches to be posted,
+// closing it again does nothing.
+func (s *Webhook) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		s.closed = true
+		s.flush(true)
+		s.mu.Unlock()
+		close(s.batches)
+		s.wg.Wait()
+	})
+	return nil
+}